@@ -1,11 +1,13 @@
-use crate::{AppState, BASE64_URL, Confirm, GameKind, RECORD_PREFIX, Submit, WinClaim};
+use crate::{
+    AppState, BASE64_URL, Confirm, GameKind, RECORD_PREFIX, Submit, WATCH_PREFIX, WinClaim,
+};
 use base64::Engine;
 use c6ol_core::{
     game::{Move, RecordEncodingScheme, Stone},
-    protocol::{GameOptions, Player, Request},
+    protocol::{GameOptions, MAX_CHAT_LEN, Player, Rejection, Request, is_valid_chat},
 };
 use leptos::{
-    either::{Either, EitherOf3, EitherOf6},
+    either::{Either, EitherOf3, EitherOf7},
     html,
     prelude::*,
 };
@@ -105,13 +107,14 @@ macro_rules! dialogs {
     };
 }
 
-dialogs!(EitherOf6 {
+dialogs!(EitherOf7 {
     A => MainMenu,
     B => OnlineMenu,
     C => Auth,
     D => GameMenu,
     E => Confirm,
     F => Reset,
+    G => Chat,
 });
 
 #[derive(Clone)]
@@ -150,17 +153,26 @@ pub enum OnlineMenuRetVal {
     Cancel,
     Start(GameOptions),
     Join(String),
+    Match,
+}
+
+#[derive(Clone, Copy)]
+enum OnlineAction {
+    Start,
+    Join,
+    Match,
 }
 
 impl DialogView for OnlineMenuDialog {
     type RetVal = OnlineMenuRetVal;
 
     fn contents(self) -> impl IntoView {
-        let start_checked = RwSignal::new(true);
+        let action = RwSignal::new(OnlineAction::Start);
 
-        let start_or_join = move || {
-            if start_checked.get() {
+        let action_view = move || match action.get() {
+            OnlineAction::Start => {
                 let stone = RwSignal::new(Stone::Black);
+                let exact_six = RwSignal::new(false);
 
                 let view = view! {
                     <table>
@@ -184,20 +196,42 @@ impl DialogView for OnlineMenuDialog {
                                 <label for="white">"White"</label>
                             </td>
                         </tr>
+                        <tr>
+                            <td style="text-align: right;">"Overlines: "</td>
+                            <td style="text-align: center;">
+                                <input
+                                    type="radio"
+                                    id="overline-win"
+                                    name="overline"
+                                    checked
+                                    on:input=move |_| exact_six.set(false)
+                                />
+                                <label for="overline-win">"Win"</label>
+                                <input
+                                    type="radio"
+                                    id="overline-no-win"
+                                    name="overline"
+                                    on:input=move |_| exact_six.set(true)
+                                />
+                                <label for="overline-no-win">"No Win"</label>
+                            </td>
+                        </tr>
                     // TODO: More options.
                     </table>
                     <div class="btn-group reversed">
                         <button on:click=move |_| {
                             let options = GameOptions {
                                 swapped: stone.get() == Stone::White,
+                                exact_six: exact_six.get(),
                             };
                             ret!(Start(options));
                         }>"Start"</button>
                         <button>"Cancel"</button>
                     </div>
                 };
-                Either::Left(view)
-            } else {
+                EitherOf3::A(view)
+            }
+            OnlineAction::Join => {
                 let game_id = RwSignal::new(String::new());
 
                 let view = view! {
@@ -220,7 +254,17 @@ impl DialogView for OnlineMenuDialog {
                         <button formnovalidate>"Cancel"</button>
                     </div>
                 };
-                Either::Right(view)
+                EitherOf3::B(view)
+            }
+            OnlineAction::Match => {
+                let view = view! {
+                    <p>"Play a new game with the next player to match."</p>
+                    <div class="btn-group reversed">
+                        <button on:click=move |_| ret!(Match)>"Match"</button>
+                        <button>"Cancel"</button>
+                    </div>
+                };
+                EitherOf3::C(view)
             }
         };
 
@@ -232,18 +276,25 @@ impl DialogView for OnlineMenuDialog {
                     id="start"
                     name="action"
                     checked
-                    on:input=move |_| start_checked.set(true)
+                    on:input=move |_| action.set(OnlineAction::Start)
                 />
                 <label for="start">"Start"</label>
                 <input
                     type="radio"
                     id="join"
                     name="action"
-                    on:input=move |_| start_checked.set(false)
+                    on:input=move |_| action.set(OnlineAction::Join)
                 />
                 <label for="join">"Join"</label>
+                <input
+                    type="radio"
+                    id="match"
+                    name="action"
+                    on:input=move |_| action.set(OnlineAction::Match)
+                />
+                <label for="match">"Match"</label>
             </div>
-            {start_or_join}
+            {action_view}
         }
     }
 }
@@ -304,6 +355,9 @@ pub enum GameMenuRetVal {
     Resign,
     Submit,
     Draw,
+    Chat,
+    ShareWatchLink,
+    RevokeWatchLink,
 }
 
 impl DialogView for GameMenuDialog {
@@ -321,6 +375,9 @@ impl DialogView for GameMenuDialog {
             record,
             win_claim,
             requests,
+            options,
+            seats_filled,
+            watch_token,
             ..
         } = *use_context::<Arc<AppState>>().unwrap();
 
@@ -334,6 +391,7 @@ impl DialogView for GameMenuDialog {
                     GameKind::Pending => Either::Left("Pending"),
                     GameKind::Local => Either::Left("Local"),
                     GameKind::Record => Either::Left("Record"),
+                    GameKind::Watching(_) => Either::Left("Watching"),
                     GameKind::Online(id) => {
                         let href = format!("#{id}");
                         Either::Right(
@@ -344,6 +402,12 @@ impl DialogView for GameMenuDialog {
                                     Some(stone) => format!("Playing {stone}"),
                                     None => "View Only".into(),
                                 }}
+                                {move || {
+                                    options
+                                        .get()
+                                        .is_some_and(|options| options.exact_six)
+                                        .then(|| view! { <br />"No Overlines" })
+                                }}
                             },
                         )
                     }
@@ -352,6 +416,9 @@ impl DialogView for GameMenuDialog {
             <br />
             {move || {
                 let record = record.read();
+                if online && !seats_filled.get() {
+                    return "Waiting for Opponent".into();
+                }
                 if let Some(stone) = record.turn() {
                     return format!("{stone} to Play");
                 }
@@ -385,6 +452,14 @@ impl DialogView for GameMenuDialog {
             >
                 "Export"
             </a>
+            {move || {
+                watch_token
+                    .get()
+                    .map(|token| {
+                        let href = format!("#{WATCH_PREFIX}{token}");
+                        view! { " " <a href=href>"Watch Link"</a> }
+                    })
+            }}
         };
 
         let ctrl_view = move || {
@@ -536,6 +611,8 @@ impl DialogView for GameMenuDialog {
         let maybe_auth_btn_or_ctrl_view = move || {
             if game_kind.get() == GameKind::Pending {
                 EitherOf3::A(())
+            } else if let GameKind::Watching(_) = game_kind.get() {
+                EitherOf3::A(())
             } else if online && player.get().is_none() {
                 EitherOf3::B(view! { <button on:click=move |_| ret!(Auth)>"Authenticate"</button> })
             } else {
@@ -549,6 +626,29 @@ impl DialogView for GameMenuDialog {
             <div class="menu-btn-group">
                 <button on:click=move |_| ret!(MainMenu)>"Main Menu"</button>
                 {maybe_auth_btn_or_ctrl_view}
+                {online.then(|| view! { <button on:click=move |_| ret!(Chat)>"Chat"</button> })}
+                {move || {
+                    (player.get() == Some(Player::Host))
+                        .then(|| {
+                            if watch_token.get().is_none() {
+                                Either::Left(
+                                    view! {
+                                        <button on:click=move |_| ret!(ShareWatchLink)>
+                                            "Share Watch Link"
+                                        </button>
+                                    },
+                                )
+                            } else {
+                                Either::Right(
+                                    view! {
+                                        <button on:click=move |_| ret!(RevokeWatchLink)>
+                                            "Revoke Watch Link"
+                                        </button>
+                                    },
+                                )
+                            }
+                        })
+                }}
                 <button autofocus>"Resume"</button>
             </div>
         }
@@ -591,6 +691,8 @@ impl DialogView for ConfirmDialog {
                 Submit::OneAndPass => "Place one stone and pass?",
                 Submit::One => "Place one stone?",
                 Submit::Two => "Place two stones?",
+                Submit::PremoveOne => "Place one stone once it is your turn?",
+                Submit::PremoveTwo => "Place two stones once it is your turn?",
             },
             Confirm::BeginClaim => {
                 (confirm, cancel) = ("Noted", None);
@@ -640,6 +742,24 @@ impl DialogView for ConfirmDialog {
                     "Resign for which stone?"
                 }
             }
+            Confirm::Rejected(reason) => {
+                (confirm, cancel) = ("Noted", None);
+                match reason {
+                    Rejection::SeatsOpen => "Not both players have joined yet.",
+                    Rejection::UnexpectedMessage => "The server did not expect that.",
+                    Rejection::NotTheirTurn => "It is not your turn.",
+                    Rejection::Occupied => "The position is occupied.",
+                    Rejection::IllegalMove => "The move is illegal.",
+                    Rejection::DuplicateRequest => "You have already made a request.",
+                    Rejection::NoPastMove => "There is no move to retract.",
+                    Rejection::NoRequest => "The opponent has made no request.",
+                    Rejection::StaleEpoch => "The game was reset before your message arrived.",
+                }
+            }
+            Confirm::PremoveFailed => {
+                (confirm, cancel) = ("Noted", None);
+                "Your premove could not be made. Please place your stones again."
+            }
             Confirm::ConnClosed(ref reason) => {
                 title = Some("Connection Closed");
                 (confirm, cancel) = ("Retry", Some("Menu"));
@@ -713,9 +833,82 @@ impl DialogView for ResetDialog {
                 <button>"Cancel"</button>
                 <button on:click=move |_| {
                     let swapped = self.old_options.swapped ^ (old_stone != new_stone.get());
-                    ret!(Confirm(GameOptions { swapped }));
+                    let options = GameOptions {
+                        swapped,
+                        ..self.old_options
+                    };
+                    ret!(Confirm(options));
                 }>"Confirm"</button>
             </div>
         }
     }
 }
+
+#[derive(Clone)]
+pub struct ChatDialog;
+
+#[derive(Debug, Default)]
+pub enum ChatRetVal {
+    #[default]
+    Close,
+    Send(String),
+}
+
+impl DialogView for ChatDialog {
+    type RetVal = ChatRetVal;
+
+    fn contents(self) -> impl IntoView {
+        let AppState {
+            chats,
+            options,
+            player,
+            ..
+        } = *use_context::<Arc<AppState>>().unwrap();
+
+        let text = RwSignal::new(String::new());
+
+        let sender_name = move |sender: Option<Player>| match (sender, options.get()) {
+            (Some(sender), _) if Some(sender) == player.get() => "You".into(),
+            (Some(sender), Some(options)) => options.stone_of(sender).to_string(),
+            (Some(_), None) => "Player".into(),
+            (None, _) => "Spectator".into(),
+        };
+
+        view! {
+            <p class="title">"Chat"</p>
+            <div class="chat-list">
+                {move || {
+                    chats
+                        .get()
+                        .into_iter()
+                        .map(|(sender, text)| {
+                            view! {
+                                <p>
+                                    <b>{sender_name(sender)}": "</b>
+                                    {text.into_string()}
+                                </p>
+                            }
+                        })
+                        .collect_view()
+                }}
+            </div>
+            <input
+                type="text"
+                id="chat-text"
+                required
+                maxlength=MAX_CHAT_LEN.to_string()
+                placeholder="Say something"
+                bind:value=text
+            />
+            <div class="btn-group reversed">
+                <button on:click=move |_| {
+                    let text = text.get();
+                    if is_valid_chat(&text) {
+                        ret!(Send(text));
+                    }
+                }>"Send"</button>
+                <button formnovalidate>"Close"</button>
+            </div>
+        }
+    }
+}
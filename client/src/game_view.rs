@@ -261,6 +261,11 @@ pub(crate) fn GameView(
     disabled: impl Fn() -> bool + Send + Sync + 'static,
     pending: impl Fn() -> bool + Send + Sync + 'static,
     replaying: impl Fn() -> bool + Copy + 'static,
+    /// Whether only rows of exactly six stones win.
+    exact_six: impl Fn() -> bool + Copy + 'static,
+    /// Whether stones can be placed during the opponent's turn,
+    /// to be submitted as a premove.
+    premoving: impl Fn() -> bool + Copy + 'static,
     on_event: impl Fn(Event) + Copy + 'static,
     /// Size of the view.
     ///
@@ -318,6 +323,23 @@ pub(crate) fn GameView(
         stone.is_some() && stone == record.read().turn()
     };
 
+    // Tests if it is the opponent's turn and we can premove.
+    let can_premove = move || {
+        premoving()
+            && stone
+                .get()
+                .is_some_and(|stone| record.read().turn() == Some(stone.opposite()))
+    };
+
+    // Returns the number of stones to place, which is always two for a premove.
+    let max_stones = move || {
+        if our_turn() {
+            record.read().max_stones_to_play()
+        } else {
+            2
+        }
+    };
+
     // Hits the cursor.
     //
     // Hitting an empty position puts a phantom stone there if there are not
@@ -349,7 +371,12 @@ pub(crate) fn GameView(
                     if record
                         .write_untracked()
                         .with_temp_placements(stone, &tent, |record| {
-                            record.test_winning_row(p, dir) == Some(cursor)
+                            let end = if exact_six() {
+                                record.test_exact_winning_row(p, dir)
+                            } else {
+                                record.test_winning_row(p, dir)
+                            };
+                            end == Some(cursor)
                         })
                     {
                         WinClaim::Ready(p, dir)
@@ -366,7 +393,7 @@ pub(crate) fn GameView(
             return;
         }
 
-        if !our_turn() || record.read().stone_at(cursor).is_some() {
+        if !(our_turn() || can_premove()) || record.read().stone_at(cursor).is_some() {
             return;
         }
 
@@ -374,11 +401,11 @@ pub(crate) fn GameView(
             if let Some(i) = tent.iter().position(|&p| p == cursor) {
                 tent.remove(i);
                 tentatives.set(tent);
-            } else if tent.len() < record.read().max_stones_to_play() {
+            } else if tent.len() < max_stones() {
                 tent.push(cursor);
                 tentatives.set(tent);
 
-                if tent.len() == record.read().max_stones_to_play() {
+                if tent.len() == max_stones() {
                     on_event(Event::Submit);
                 }
             }
@@ -390,10 +417,10 @@ pub(crate) fn GameView(
             tent.push(cursor);
             tentatives.set(tent);
 
-            if tent.len() == record.read().max_stones_to_play() {
+            if tent.len() == max_stones() {
                 on_event(Event::Submit);
             }
-        } else if tent.len() < record.read().max_stones_to_play() {
+        } else if tent.len() < max_stones() {
             phantom_pos.set(Some(cursor));
         }
     };
@@ -8,7 +8,8 @@ use base64::engine::{DecodePaddingMode, Engine, GeneralPurpose, GeneralPurposeCo
 use c6ol_core::{
     game::{Direction, Move, Point, Record, RecordEncodingScheme, Stone},
     protocol::{
-        ClientMessage, GameId, GameOptions, Message, Player, PlayerSlots, Request, ServerMessage,
+        ClientMessage, GameId, GameOptions, Message, Player, PlayerSlots, Rejection, Request,
+        ServerMessage, WatchToken,
     },
 };
 use dialog::*;
@@ -58,6 +59,8 @@ enum Submit {
     OneAndPass,
     One,
     Two,
+    PremoveOne,
+    PremoveTwo,
 }
 
 #[derive(Clone)]
@@ -72,6 +75,8 @@ enum Confirm {
     RequestAccepted,
     RequestDeclined,
     Resign,
+    Rejected(Rejection),
+    PremoveFailed,
     ConnClosed(String),
     Error(String),
 }
@@ -97,6 +102,7 @@ enum WinClaim {
 const STORAGE_KEY_RECORD: &str = "record";
 const RECORD_PREFIX_LEGACY: &str = "analyze,";
 const RECORD_PREFIX: &str = "r=";
+const WATCH_PREFIX: &str = "w=";
 
 #[derive(Clone)]
 struct DialogEntry {
@@ -133,17 +139,21 @@ fn history_push_state(url: &str) {
 
 const RECONNECT_TIMEOUT: Duration = Duration::from_millis(500);
 
+/// The maximum number of chat messages to keep.
+const MAX_CHATS: usize = 100;
+
 #[derive(Clone, Copy, Eq, PartialEq)]
 enum GameKind {
     Pending,
     Local,
     Record,
     Online(GameId),
+    Watching(WatchToken),
 }
 
 impl GameKind {
     fn is_online(self) -> bool {
-        matches!(self, Self::Online(_))
+        matches!(self, Self::Online(_) | Self::Watching(_))
     }
 }
 
@@ -155,6 +165,9 @@ struct AppState {
     player: RwSignal<Option<Player>>,
     requests: RwSignal<PlayerSlots<Option<Request>>>,
     options: RwSignal<Option<GameOptions>>,
+    seats_filled: RwSignal<bool>,
+    watch_token: RwSignal<Option<WatchToken>>,
+    chats: RwSignal<Vec<(Option<Player>, Box<str>)>>,
     stone: Memo<Option<Stone>>,
 }
 
@@ -165,15 +178,19 @@ pub fn App() -> impl IntoView {
 
     let tentatives = RwSignal::new(ArrayVec::new());
     let win_claim = RwSignal::new(None);
+    let view_center = RwSignal::new(Point::ZERO);
 
     let game_kind = RwSignal::new(GameKind::Pending);
 
     let player = RwSignal::new(None::<Player>);
     let requests = RwSignal::new(PlayerSlots::<Option<Request>>::default());
     let options = RwSignal::new(None::<GameOptions>);
+    let seats_filled = RwSignal::new(false);
+    let watch_token = RwSignal::new(None::<WatchToken>);
+    let chats = RwSignal::new(Vec::new());
 
     let stone = Memo::new(move |_| match game_kind.get() {
-        GameKind::Pending => None,
+        GameKind::Pending | GameKind::Watching(_) => None,
         GameKind::Local | GameKind::Record => record.read().turn(),
         GameKind::Online(_) => Some(options.get()?.stone_of(player.get()?)),
     });
@@ -186,6 +203,9 @@ pub fn App() -> impl IntoView {
         player,
         requests,
         options,
+        seats_filled,
+        watch_token,
+        chats,
         stone,
     }));
 
@@ -273,7 +293,7 @@ pub fn App() -> impl IntoView {
                 }
             }
             ServerMessage::Options(new_options) => {
-                if player.get().is_some() {
+                if player.get().is_some() || matches!(game_kind.get(), GameKind::Watching(_)) {
                     if options.get().is_none() {
                         show_game_menu_dialog();
                     }
@@ -283,6 +303,8 @@ pub fn App() -> impl IntoView {
                 options.set(Some(new_options));
             }
             ServerMessage::Record(new_record) => {
+                // Focus on where the stones are.
+                view_center.set(new_record.center());
                 record.set(*new_record);
                 record_changed = true;
             }
@@ -320,6 +342,28 @@ pub fn App() -> impl IntoView {
                     confirm(Confirm::RequestDeclined);
                 }
             }
+            ServerMessage::Chat(sender, text) => {
+                let mut chats = chats.write();
+                if chats.len() == MAX_CHATS {
+                    chats.remove(0);
+                }
+                chats.push((sender, text));
+            }
+            ServerMessage::SeatsFilled => seats_filled.set(true),
+            ServerMessage::PremoveFailed(failed_player) => {
+                if player.get() == Some(failed_player) {
+                    confirm(Confirm::PremoveFailed);
+                }
+            }
+            ServerMessage::Rejected(reason) => confirm(Confirm::Rejected(reason)),
+            ServerMessage::Epoch(epoch) => {
+                // Anything we send from now on is meant for the game in this epoch.
+                send(ClientMessage::AckEpoch(epoch));
+            }
+            ServerMessage::WatchToken(token) => {
+                watch_token.set(Some(token));
+                show_game_menu_dialog();
+            }
         }
 
         if record_changed {
@@ -350,6 +394,9 @@ pub fn App() -> impl IntoView {
         player.set(None);
         requests.write().fill(None);
         options.set(None);
+        seats_filled.set(false);
+        watch_token.set(None);
+        chats.write().clear();
 
         dialog_entries.write().clear();
     };
@@ -373,8 +420,9 @@ pub fn App() -> impl IntoView {
         let ws = WebSocket::new(&format!("{proto}//{host}/ws")).unwrap();
         ws.set_binary_type(BinaryType::Arraybuffer);
 
-        let onopen = Closure::once(move || {
-            send(init_msg);
+        let onopen = Closure::once({
+            let init_msg = init_msg.clone();
+            move || send(init_msg)
         });
         ws.set_onopen(Some(onopen.as_ref().unchecked_ref()));
 
@@ -390,19 +438,19 @@ pub fn App() -> impl IntoView {
                 let mut state = ws_state.write_untracked();
                 let state = state.as_mut().unwrap();
 
+                // Rejoin the game, or keep watching it.
+                let init_msg = match game_kind.get() {
+                    GameKind::Online(id) => Some(ClientMessage::Join(id)),
+                    GameKind::Watching(token) => Some(ClientMessage::Watch(token)),
+                    _ => None,
+                };
                 if state.was_active
-                    && let GameKind::Online(id) = game_kind.get()
+                    && let Some(init_msg) = init_msg
                 {
                     state.reconnect_handle = set_timeout_with_handle(
                         move || {
                             connect(
-                                ClientMessage::Join(id),
-                                ws_state,
-                                game_kind,
-                                send,
-                                clear_all,
-                                on_message,
-                                confirm,
+                                init_msg, ws_state, game_kind, send, clear_all, on_message, confirm,
                             );
                         },
                         RECONNECT_TIMEOUT,
@@ -511,6 +559,15 @@ pub fn App() -> impl IntoView {
             return;
         }
 
+        if let Some(token) = id.strip_prefix(WATCH_PREFIX)
+            && let Some(token) = WatchToken::from_base62(token.as_bytes())
+        {
+            game_kind.set(GameKind::Watching(token));
+
+            connect(ClientMessage::Watch(token));
+            return;
+        }
+
         confirm(Confirm::Error("Invalid game ID.".into()));
     };
 
@@ -522,6 +579,11 @@ pub fn App() -> impl IntoView {
             if online() {
                 confirm(match claim {
                     Some(WinClaim::Ready(..)) => Confirm::Claim,
+                    // Stones placed during the opponent's turn.
+                    _ if record.read().turn() != stone.get() => Confirm::Submit(match tent.len() {
+                        1 => Submit::PremoveOne,
+                        _ => Submit::PremoveTwo,
+                    }),
                     _ => Confirm::Submit(match tent.len() {
                         0 => Submit::Pass,
                         1 if record.read().has_past() => Submit::OneAndPass,
@@ -646,6 +708,12 @@ pub fn App() -> impl IntoView {
         GameMenuRetVal::Resign => on_event(Event::Resign),
         GameMenuRetVal::Submit => on_event(Event::Submit),
         GameMenuRetVal::Draw => on_event(Event::Draw),
+        GameMenuRetVal::Chat => show_dialog(Dialog::from(ChatDialog)),
+        GameMenuRetVal::ShareWatchLink => send(ClientMessage::GetWatchToken),
+        GameMenuRetVal::RevokeWatchLink => {
+            send(ClientMessage::RevokeWatchToken);
+            watch_token.set(None);
+        }
     };
 
     let on_dialog_return = move |id: u32, ret_val: RetVal| {
@@ -672,6 +740,7 @@ pub fn App() -> impl IntoView {
                 }
                 OnlineMenuRetVal::Start(options) => connect(ClientMessage::Start(options)),
                 OnlineMenuRetVal::Join(game_id) => set_game_id(&game_id),
+                OnlineMenuRetVal::Match => connect(ClientMessage::Match),
             },
             RetVal::Auth(ret_val) => match ret_val {
                 AuthRetVal::ViewOnly => {}
@@ -703,10 +772,12 @@ pub fn App() -> impl IntoView {
 
                 match confirm {
                     Confirm::MainMenu => set_game_id(""),
-                    Confirm::Submit(_) => {
+                    Confirm::Submit(submit) => {
                         let tent = tentatives.get();
                         if tent.is_empty() {
                             send(ClientMessage::Pass);
+                        } else if let Submit::PremoveOne | Submit::PremoveTwo = submit {
+                            send(ClientMessage::Premove(tent[0], tent.get(1).copied()));
                         } else {
                             send(ClientMessage::Place(tent[0], tent.get(1).copied()));
                         }
@@ -745,8 +816,9 @@ pub fn App() -> impl IntoView {
                             record.write().make_move(Move::Resign(resigned_stone));
                         }
                     }
+                    Confirm::Rejected(_) | Confirm::PremoveFailed => {}
                     Confirm::ConnClosed(_) => {
-                        let init_msg = ws_state.read().as_ref().map(|s| s.init_msg);
+                        let init_msg = ws_state.read().as_ref().map(|s| s.init_msg.clone());
                         if let Some(init_msg) = init_msg {
                             connect(init_msg);
                         }
@@ -760,6 +832,13 @@ pub fn App() -> impl IntoView {
                     send(ClientMessage::Request(Request::Reset(options)));
                 }
             },
+            RetVal::Chat(ret_val) => match ret_val {
+                ChatRetVal::Close => {}
+                ChatRetVal::Send(text) => {
+                    send(ClientMessage::Chat(text.into()));
+                    show_dialog(Dialog::from(ChatDialog));
+                }
+            },
         }
     };
 
@@ -793,7 +872,10 @@ pub fn App() -> impl IntoView {
             disabled=move || !dialog_entries.read().is_empty()
             pending=move || online() && options.get().is_none()
             replaying=move || game_kind.get() == GameKind::Record
+            exact_six=move || options.get().is_some_and(|options| options.exact_six)
+            premoving=move || online() && player.get().is_some()
             on_event=on_event
+            view_center=view_center
             tentatives=tentatives
             win_claim=win_claim
         />
@@ -1,6 +1,8 @@
 //! Connect6 game logic, record, and serialization.
 
 mod nibble;
+mod notation;
+mod threat;
 
 #[cfg(test)]
 mod tests;
@@ -8,15 +10,18 @@ mod tests;
 use bytes::{Buf, BufMut};
 use bytes_varint::{VarIntSupport, VarIntSupportMut};
 use std::{
-    collections::HashMap,
+    collections::{BTreeMap, HashMap, HashSet},
     fmt, iter,
     ops::{Add, AddAssign, Sub, SubAssign},
 };
 
+use crate::protocol::GameOptions;
 use nibble::{NibbleReader, NibbleWriter};
 
+pub use threat::{Hint, Threat};
+
 /// A direction on the board.
-#[derive(Clone, Copy, Debug, Eq, PartialEq)]
+#[derive(Clone, Copy, Debug, Eq, Hash, PartialEq)]
 pub enum Direction {
     /// North, with a unit vector of `(0, -1)`.
     North = 0,
@@ -102,6 +107,21 @@ impl Direction {
     }
 }
 
+impl fmt::Display for Direction {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(match self {
+            Self::North => "North",
+            Self::Northeast => "Northeast",
+            Self::East => "East",
+            Self::Southeast => "Southeast",
+            Self::South => "South",
+            Self::Southwest => "Southwest",
+            Self::West => "West",
+            Self::Northwest => "Northwest",
+        })
+    }
+}
+
 fn zigzag_encode(n: i16) -> u16 {
     ((n << 1) ^ (n >> 15)) as u16
 }
@@ -157,6 +177,40 @@ impl Point {
         Self::new(zigzag_decode(x), zigzag_decode(y))
     }
 
+    /// Returns a compact label of the point, which is its index in base 36.
+    #[must_use]
+    pub fn label(self) -> String {
+        let mut n = self.index();
+        let mut label = vec![];
+        loop {
+            label.push(char::from_digit(n % 36, 36).unwrap());
+            n /= 36;
+            if n == 0 {
+                break;
+            }
+        }
+        label.into_iter().rev().collect()
+    }
+
+    /// Parses a label into a point (undoes `label`).
+    ///
+    /// Returns `None` if the label is not in canonical form.
+    #[must_use]
+    pub fn from_label(label: &str) -> Option<Self> {
+        if label.is_empty() || (label.len() > 1 && label.starts_with('0')) {
+            return None;
+        }
+
+        let mut n = 0u32;
+        for c in label.chars() {
+            if c.is_ascii_uppercase() {
+                return None;
+            }
+            n = n.checked_mul(36)?.checked_add(c.to_digit(36)?)?;
+        }
+        Some(Self::from_index(n))
+    }
+
     /// Maps the point to a natural number, with a set of
     /// centrosymmetric points mapped to a single number.
     #[must_use]
@@ -222,6 +276,13 @@ impl Point {
         })
     }
 
+    /// Tests if the point is within the range where stones can be placed.
+    #[must_use]
+    pub fn is_placeable(self) -> bool {
+        // Avoid overflow for delta and varint encoding
+        self.x.unsigned_abs().max(self.y.unsigned_abs()) <= 0x3fff
+    }
+
     /// Performs checked addition.
     #[must_use]
     pub fn checked_add(self, rhs: Self) -> Option<Self> {
@@ -266,6 +327,12 @@ impl Point {
     }
 }
 
+impl fmt::Display for Point {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "({}, {})", self.x, self.y)
+    }
+}
+
 impl Add for Point {
     type Output = Self;
 
@@ -550,6 +617,43 @@ impl PartialEq for Move {
 
 impl Eq for Move {}
 
+/// A summary of an ended game.
+#[derive(Clone, Copy, Debug, Eq, PartialEq)]
+pub struct Summary {
+    /// The winning stone, or `None` if the game is drawn.
+    pub winner: Option<Stone>,
+    /// The ending move, telling how the game ended and the winning row (if any).
+    pub ending: Move,
+    /// The number of moves made before the ending move.
+    pub move_count: usize,
+}
+
+/// The difference between the stones on two boards, each ordered by
+/// the index of their positions.
+#[derive(Clone, Debug, Default, Eq, PartialEq)]
+pub struct Diff {
+    /// The stones present only on the other board.
+    pub added: Vec<(Point, Stone)>,
+    /// The stones present only on this board.
+    pub removed: Vec<(Point, Stone)>,
+}
+
+/// A maximal row of adjacent stones of one color, with the status of
+/// the positions beyond its ends.
+#[derive(Clone, Copy, Debug, Eq, PartialEq)]
+pub struct Run {
+    /// The endpoint at the backward end.
+    pub start: Point,
+    /// The endpoint at the forward end.
+    pub end: Point,
+    /// The number of stones.
+    pub len: usize,
+    /// Whether the position before `start` is empty and placeable.
+    pub open_start: bool,
+    /// Whether the position after `end` is empty and placeable.
+    pub open_end: bool,
+}
+
 /// Scheme to encode a game record with.
 #[derive(Clone, Copy, Debug, Eq, PartialEq)]
 pub struct RecordEncodingScheme {
@@ -598,12 +702,19 @@ impl RecordEncodingScheme {
     }
 }
 
+/// The maximum length of a comment in bytes.
+pub const MAX_COMMENT_LEN: usize = 1024;
+
+// Set in the encoded scheme if the record has comments.
+const SCHEME_FLAG_COMMENTS: u8 = 4;
+
 /// A Connect6 game record.
 #[derive(Clone, Debug, Default, Eq, PartialEq)]
 pub struct Record {
     map: HashMap<Point, Stone>,
     moves: Vec<Move>,
     index: usize,
+    comments: BTreeMap<usize, Box<str>>,
 }
 
 impl Record {
@@ -614,6 +725,7 @@ impl Record {
             map: HashMap::new(),
             moves: vec![],
             index: 0,
+            comments: BTreeMap::new(),
         }
     }
 
@@ -622,11 +734,22 @@ impl Record {
         self.map.clear();
         self.moves.clear();
         self.index = 0;
+        self.comments.clear();
     }
 
-    /// Clears future moves.
+    /// Clears future moves, along with their comments.
     pub fn clear_future(&mut self) {
         self.moves.truncate(self.index);
+        self.comments.split_off(&self.index);
+    }
+
+    /// Shrinks the capacity of the record as much as possible,
+    /// keeping all moves.
+    ///
+    /// Call `clear_future` first to also drop the future moves.
+    pub fn shrink_to_fit(&mut self) {
+        self.map.shrink_to_fit();
+        self.moves.shrink_to_fit();
     }
 
     /// Returns a slice of all moves, past and future.
@@ -653,6 +776,35 @@ impl Record {
         self.moves.get(self.index).copied()
     }
 
+    /// Returns the move at the given index (if any), past or future.
+    #[must_use]
+    pub fn nth_move(&self, n: usize) -> Option<Move> {
+        self.moves.get(n).copied()
+    }
+
+    /// Returns the comment on the move at the given index (if any).
+    #[must_use]
+    pub fn comment(&self, index: usize) -> Option<&str> {
+        self.comments.get(&index).map(|text| &text[..])
+    }
+
+    /// Sets the comment on the move at the given index, past or future.
+    /// An empty comment removes the existing one.
+    ///
+    /// Returns whether the comment was set, which fails if there is no move
+    /// at the index or the comment is longer than `MAX_COMMENT_LEN` bytes.
+    pub fn set_comment(&mut self, index: usize, text: &str) -> bool {
+        if index >= self.moves.len() || text.len() > MAX_COMMENT_LEN {
+            return false;
+        }
+        if text.is_empty() {
+            self.comments.remove(&index);
+        } else {
+            self.comments.insert(index, text.into());
+        }
+        true
+    }
+
     /// Tests if there is any move in the past.
     #[must_use]
     pub fn has_past(&self) -> bool {
@@ -671,6 +823,34 @@ impl Record {
         self.prev_move().is_some_and(Move::is_ending)
     }
 
+    /// Returns a summary of the game, or `None` if the game is not ended.
+    #[must_use]
+    pub fn summary(&self) -> Option<Summary> {
+        let ending = self.prev_move().filter(|mov| mov.is_ending())?;
+        let winner = match ending {
+            Move::Win(p, _) => self.stone_at(p),
+            Move::Resign(stone) => Some(stone.opposite()),
+            _ => None,
+        };
+        Some(Summary {
+            winner,
+            ending,
+            move_count: self.index - 1,
+        })
+    }
+
+    /// Returns the endpoints of the winning row if the game is won with one.
+    ///
+    /// The row is taken from the claim in the record, so no search is done,
+    /// and it goes away once the claim is undone.
+    #[must_use]
+    pub fn winning_row(&self) -> Option<(Point, Point)> {
+        let Some(Move::Win(p, dir)) = self.prev_move() else {
+            return None;
+        };
+        Some((p, self.test_winning_row(p, dir)?))
+    }
+
     /// Returns the maximum number of stones to play in the current turn.
     #[must_use]
     pub fn max_stones_to_play(&self) -> usize {
@@ -694,14 +874,129 @@ impl Record {
         (!self.is_ended()).then(|| self.turn_unchecked())
     }
 
+    /// Returns the stone to play and the maximum number of stones to play
+    /// after making the given moves, without changing the record.
+    ///
+    /// Returns `None` if any of the moves fails or the game would be ended.
+    #[must_use]
+    pub fn turn_after(&self, moves: &[Move]) -> Option<(Stone, usize)> {
+        let mut record = self.clone();
+        record.make_moves(moves).ok()?;
+        Some((record.turn()?, record.max_stones_to_play()))
+    }
+
     /// Returns the stone at the given position (if any).
     #[must_use]
     pub fn stone_at(&self, p: Point) -> Option<Stone> {
         self.map.get(&p).copied()
     }
 
+    /// Returns the index of the move that placed the stone at the given
+    /// position, or `None` if the position is empty.
+    #[must_use]
+    pub fn move_index_at(&self, p: Point) -> Option<usize> {
+        if !self.map.contains_key(&p) {
+            return None;
+        }
+        self.moves[..self.index]
+            .iter()
+            .position(|&mov| matches!(mov, Move::Place(p1, p2) if p1 == p || p2 == Some(p)))
+    }
+
+    /// Returns an iterator of the stones on the board, in the order
+    /// they were placed.
+    pub fn stones(&self) -> impl Iterator<Item = (Point, Stone)> + '_ {
+        self.moves[..self.index]
+            .iter()
+            .enumerate()
+            .flat_map(|(i, &mov)| {
+                let stone = Stone::turn_at(i);
+                let (p1, p2) = match mov {
+                    Move::Place(p1, p2) => (Some(p1), p2),
+                    _ => (None, None),
+                };
+                p1.into_iter().chain(p2).map(move |p| (p, stone))
+            })
+    }
+
+    /// Returns the center of the bounding box of the stones on the board,
+    /// rounding towards negative infinity, or the origin if there are none.
+    ///
+    /// This is meant as a hint on where to focus when showing the board.
+    #[must_use]
+    pub fn center(&self) -> Point {
+        let mut points = self.map.keys();
+        let Some(&first) = points.next() else {
+            return Point::ZERO;
+        };
+        let (min, max) = points.fold((first, first), |(min, max), &p| {
+            (
+                Point::new(min.x.min(p.x), min.y.min(p.y)),
+                Point::new(max.x.max(p.x), max.y.max(p.y)),
+            )
+        });
+        min.midpoint_floor(max)
+    }
+
+    /// Returns the stones within the given Chebyshev distance from `center`,
+    /// ordered by the index of their positions.
+    #[must_use]
+    pub fn stones_within(&self, center: Point, radius: u16) -> Vec<(Point, Stone)> {
+        let r = radius as i32;
+        let (cx, cy) = (center.x as i32, center.y as i32);
+
+        // Probe each position in the square when it has fewer positions
+        // than there are stones, so that small queries on a crowded board
+        // do not scan every stone.
+        let side = 2 * radius as u64 + 1;
+        let mut stones: Vec<_> = if side * side <= self.map.len() as u64 {
+            let range = |c: i32| (c - r).max(i16::MIN as i32)..=(c + r).min(i16::MAX as i32);
+            range(cy)
+                .flat_map(|y| range(cx).map(move |x| Point::new(x as i16, y as i16)))
+                .filter_map(|p| Some((p, self.stone_at(p)?)))
+                .collect()
+        } else {
+            self.map
+                .iter()
+                .filter(|&(&p, _)| {
+                    let dx = (p.x as i32 - cx).unsigned_abs();
+                    let dy = (p.y as i32 - cy).unsigned_abs();
+                    dx.max(dy) <= radius as u32
+                })
+                .map(|(&p, &stone)| (p, stone))
+                .collect()
+        };
+        stones.sort_by_key(|&(p, _)| p.index());
+        stones
+    }
+
+    /// Compares the stones on the board with those on another record's board.
+    ///
+    /// A position holding different stones on the boards appears as both
+    /// added and removed.
+    #[must_use]
+    pub fn diff(&self, other: &Self) -> Diff {
+        let only_in = |a: &Self, b: &Self| {
+            let mut stones: Vec<_> = a
+                .map
+                .iter()
+                .filter(|&(p, s)| b.map.get(p) != Some(s))
+                .map(|(&p, &s)| (p, s))
+                .collect();
+            stones.sort_by_key(|&(p, _)| p.index());
+            stones
+        };
+        Diff {
+            added: only_in(other, self),
+            removed: only_in(self, other),
+        }
+    }
+
     /// Makes a move, clearing moves in the future.
     ///
+    /// The first move must place exactly one stone, and later moves may
+    /// place one or two.
+    ///
     /// Returns whether the move succeeded.
     pub fn make_move(&mut self, mov: Move) -> bool {
         if self.is_ended() {
@@ -720,8 +1015,7 @@ impl Record {
             }
 
             for p in iter::once(p1).chain(p2) {
-                // Avoid overflow for delta and varint encoding
-                if p.x.unsigned_abs().max(p.y.unsigned_abs()) > 0x3fff {
+                if !p.is_placeable() {
                     return false;
                 }
                 if self.map.contains_key(&p) {
@@ -745,6 +1039,49 @@ impl Record {
         true
     }
 
+    /// Makes a move under the game options, clearing moves in the future.
+    ///
+    /// Same as `make_move`, except that with `exact_six` a win can only
+    /// be claimed on a row of exactly six stones.
+    ///
+    /// Returns whether the move succeeded.
+    pub fn make_move_with(&mut self, options: GameOptions, mov: Move) -> bool {
+        if options.exact_six
+            && let Move::Win(p, dir) = mov
+            && self.test_exact_winning_row(p, dir).is_none()
+        {
+            return false;
+        }
+        self.make_move(mov)
+    }
+
+    /// Makes the given moves in order, clearing moves in the future.
+    ///
+    /// # Errors
+    ///
+    /// Stops at the first move that fails and returns its offset in `moves`,
+    /// leaving the moves before it made.
+    pub fn make_moves(&mut self, moves: &[Move]) -> Result<(), usize> {
+        self.make_moves_with(GameOptions::default(), moves)
+    }
+
+    /// Makes the given moves in order under the game options,
+    /// clearing moves in the future.
+    ///
+    /// # Errors
+    ///
+    /// Stops at the first move that fails and returns its offset in `moves`,
+    /// leaving the moves before it made.
+    pub fn make_moves_with(&mut self, options: GameOptions, moves: &[Move]) -> Result<(), usize> {
+        match moves
+            .iter()
+            .position(|&mov| !self.make_move_with(options, mov))
+        {
+            Some(i) => Err(i),
+            None => Ok(()),
+        }
+    }
+
     /// Undoes the previous move (if any).
     pub fn undo_move(&mut self) -> Option<Move> {
         let prev = self.prev_move()?;
@@ -770,6 +1107,17 @@ impl Record {
         Some(next)
     }
 
+    /// Undoes moves back to the most recent earlier point where it was
+    /// `stone`'s turn to move, that is, before `stone`'s last move.
+    ///
+    /// Returns whether `stone` had moved, leaving the record unchanged if not.
+    pub fn undo_to_turn(&mut self, stone: Stone) -> bool {
+        let Some(index) = (0..self.index).rfind(|&i| Stone::turn_at(i) == stone) else {
+            return false;
+        };
+        self.jump(index)
+    }
+
     /// Jumps to the given move index by undoing or redoing moves.
     pub fn jump(&mut self, index: usize) -> bool {
         if index > self.moves.len() {
@@ -787,6 +1135,18 @@ impl Record {
         true
     }
 
+    /// Returns a copy of the record jumped to the given move index,
+    /// or `None` if the index is out of range.
+    #[must_use]
+    pub fn snapshot(&self, index: usize) -> Option<Self> {
+        if index > self.moves.len() {
+            return None;
+        }
+        let mut record = self.clone();
+        record.jump(index);
+        Some(record)
+    }
+
     /// Returns an iterator of adjacent positions occupied by `stone`
     /// in the direction `dir`, starting from `p` (exclusive).
     fn scan(&self, p: Point, dir: Direction, stone: Stone) -> impl Iterator<Item = Point> {
@@ -814,12 +1174,50 @@ impl Record {
         None
     }
 
+    /// Returns the run through `p` along `dir`, or `None` if `p` is empty.
+    ///
+    /// An end of the run is blocked by a stone of the other color or
+    /// by the edge of the placeable range.
+    #[must_use]
+    pub fn run_at(&self, p: Point, dir: Direction) -> Option<Run> {
+        let stone = self.stone_at(p)?;
+        let start = self.scan(p, dir.opposite(), stone).last().unwrap_or(p);
+        let end = self.scan(p, dir, stone).last().unwrap_or(p);
+        let is_open = |p: Point| p.is_placeable() && self.stone_at(p).is_none();
+
+        let len = iter::once(start)
+            .chain(self.scan(start, dir, stone))
+            .count();
+        Some(Run {
+            start,
+            end,
+            len,
+            open_start: is_open(start + dir.offset(-1)),
+            open_end: is_open(end + dir.offset(1)),
+        })
+    }
+
     /// Tests if the given winning row is valid, returning the other endpoint if so.
     #[must_use]
     pub fn test_winning_row(&self, p: Point, dir: Direction) -> Option<Point> {
         self.scan(p, dir, self.stone_at(p)?).nth(4)
     }
 
+    /// Tests if the given winning row is valid and has exactly six stones,
+    /// returning the other endpoint if so.
+    ///
+    /// Fails on a row that is part of an overline, even if the overline was
+    /// formed after the row.
+    #[must_use]
+    pub fn test_exact_winning_row(&self, p: Point, dir: Direction) -> Option<Point> {
+        let stone = self.stone_at(p)?;
+        if self.stone_at(p + dir.offset(-1)) == Some(stone) {
+            return None;
+        }
+        let end = self.scan(p, dir, stone).nth(4)?;
+        (self.stone_at(end + dir.offset(1)) != Some(stone)).then_some(end)
+    }
+
     /// Places `stone` at each of `positions` temporarily, calls `f`
     /// and returns the result after undoing the placements.
     ///
@@ -840,18 +1238,86 @@ impl Record {
         res
     }
 
+    /// Checks the internal consistency of the record, meant for tests.
+    ///
+    /// # Errors
+    ///
+    /// Returns a description of the first violated invariant, which is one of:
+    ///
+    /// - The move index is within the moves.
+    /// - No move follows an ending move.
+    /// - No position is placed twice in the past moves.
+    /// - The board holds exactly the stones placed in the past moves.
+    /// - Every comment belongs to a move.
+    pub fn check_invariants(&self) -> Result<(), &'static str> {
+        if self.index > self.moves.len() {
+            return Err("move index out of range");
+        }
+        if let Some((_, init)) = self.moves.split_last()
+            && init.iter().any(|mov| mov.is_ending())
+        {
+            return Err("move after an ending move");
+        }
+
+        let mut placed = HashSet::new();
+        for (p, stone) in self.stones() {
+            if !placed.insert(p) {
+                return Err("position placed twice");
+            }
+            if self.map.get(&p) != Some(&stone) {
+                return Err("stone missing from the board");
+            }
+        }
+        if placed.len() != self.map.len() {
+            return Err("stone not placed in the past moves");
+        }
+
+        if self
+            .comments
+            .last_key_value()
+            .is_some_and(|(&i, _)| i >= self.moves.len())
+        {
+            return Err("comment without a move");
+        }
+        Ok(())
+    }
+
     /// Encodes the record to a buffer.
     pub fn encode(&self, buf: &mut Vec<u8>, scheme: RecordEncodingScheme) {
+        let end = if scheme.all {
+            self.moves.len()
+        } else {
+            self.index
+        };
+
+        let comments = self.comments.range(..end);
+        let comment_count = comments.clone().count();
+
+        let mut scheme_u8 = scheme.as_u8();
+        if comment_count > 0 {
+            scheme_u8 |= SCHEME_FLAG_COMMENTS;
+        }
+
         if scheme.delta {
             let mut writer = NibbleWriter::new(buf);
-            writer.write_u3(scheme.as_u8());
+            writer.write_u3(scheme_u8);
 
-            let mut moves = if scheme.all {
+            if scheme.all {
                 writer.write_u32_varint(self.index as u32);
-                &self.moves
-            } else {
-                &self.moves[..self.index]
-            };
+            }
+
+            if comment_count > 0 {
+                writer.write_u32_varint(comment_count as u32);
+                for (&i, text) in comments {
+                    writer.write_u32_varint(i as u32);
+                    writer.write_u32_varint(text.len() as u32);
+                    for &b in text.as_bytes() {
+                        writer.write_u8(b);
+                    }
+                }
+            }
+
+            let mut moves = &self.moves[..end];
 
             if let [Move::Place(Point::ZERO, None), Move::Place(_, Some(_)), ..] = moves {
                 moves = &moves[1..];
@@ -862,14 +1328,20 @@ impl Record {
                 mov.encode_delta(&mut writer, &mut origin);
             }
         } else {
-            buf.put_u8(scheme.as_u8());
+            buf.put_u8(scheme_u8);
 
-            let end = if scheme.all {
+            if scheme.all {
                 buf.put_u32_varint(self.index as u32);
-                self.moves.len()
-            } else {
-                self.index
-            };
+            }
+
+            if comment_count > 0 {
+                buf.put_u32_varint(comment_count as u32);
+                for (&i, text) in comments {
+                    buf.put_u32_varint(i as u32);
+                    buf.put_u32_varint(text.len() as u32);
+                    buf.put_slice(text.as_bytes());
+                }
+            }
 
             for i in 0..end {
                 self.moves[i].encode(buf, i == 0);
@@ -893,7 +1365,9 @@ impl Record {
         }
 
         let mut reader = NibbleReader::new(buf);
-        let scheme = RecordEncodingScheme::from_u8(reader.read_u3()?)?;
+        let scheme_u8 = reader.read_u3()?;
+        let scheme = RecordEncodingScheme::from_u8(scheme_u8 & !SCHEME_FLAG_COMMENTS)?;
+        let has_comments = scheme_u8 & SCHEME_FLAG_COMMENTS != 0;
 
         if scheme.delta {
             let index = if scheme.all {
@@ -902,6 +1376,20 @@ impl Record {
                 None
             };
 
+            let mut comments = vec![];
+            if has_comments {
+                let count = reader.read_u32_varint()?;
+                for _ in 0..count {
+                    let i = reader.read_u32_varint()? as usize;
+                    let len = reader.read_u32_varint()? as usize;
+                    if len > MAX_COMMENT_LEN {
+                        return None;
+                    }
+                    let text = (0..len).map(|_| reader.read_u8()).collect::<Option<_>>()?;
+                    comments.push((i, text));
+                }
+            }
+
             let mut record = Self::new();
             let mut origin = Point::ZERO;
 
@@ -920,6 +1408,10 @@ impl Record {
                 }
             }
 
+            if !record.set_decoded_comments(comments) {
+                return None;
+            }
+
             if let Some(index) = index
                 && !record.jump(index as usize)
             {
@@ -933,6 +1425,20 @@ impl Record {
                 None
             };
 
+            let mut comments = vec![];
+            if has_comments {
+                let count = buf.try_get_u32_varint().ok()?;
+                for _ in 0..count {
+                    let i = buf.try_get_u32_varint().ok()? as usize;
+                    let len = buf.try_get_u32_varint().ok()? as usize;
+                    if len > MAX_COMMENT_LEN || len > buf.len() {
+                        return None;
+                    }
+                    comments.push((i, buf[..len].to_vec()));
+                    buf.advance(len);
+                }
+            }
+
             let mut record = Self::new();
 
             while buf.has_remaining() {
@@ -942,6 +1448,10 @@ impl Record {
                 }
             }
 
+            if !record.set_decoded_comments(comments) {
+                return None;
+            }
+
             if let Some(index) = index
                 && !record.jump(index as usize)
             {
@@ -950,4 +1460,23 @@ impl Record {
             Some(record)
         }
     }
+
+    /// Sets decoded comments, which must be in canonical form:
+    /// in strictly ascending order of index, non-empty, and valid UTF-8.
+    fn set_decoded_comments(&mut self, comments: Vec<(usize, Vec<u8>)>) -> bool {
+        let mut prev = None;
+        for (i, text) in comments {
+            if prev.is_some_and(|prev| i <= prev) || text.is_empty() {
+                return false;
+            }
+            let Ok(text) = String::from_utf8(text) else {
+                return false;
+            };
+            if !self.set_comment(i, &text) {
+                return false;
+            }
+            prev = Some(i);
+        }
+        true
+    }
 }
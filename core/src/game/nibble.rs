@@ -21,6 +21,11 @@ impl<'a> NibbleWriter<'a> {
         self.write_u4(n);
     }
 
+    pub fn write_u8(&mut self, n: u8) {
+        self.write_u4(n & 15);
+        self.write_u4(n >> 4);
+    }
+
     pub fn write_u32_varint(&mut self, mut n: u32) {
         while n >= 8 {
             self.write_u4((n as u8 & 7) | 8);
@@ -67,6 +72,12 @@ impl<'a, 'b> NibbleReader<'a, 'b> {
         self.read_u4().filter(|&n| n < 8)
     }
 
+    pub fn read_u8(&mut self) -> Option<u8> {
+        let lo = self.read_u4()?;
+        let hi = self.read_u4()?;
+        Some(lo | hi << 4)
+    }
+
     pub fn read_u32_varint(&mut self) -> Option<u32> {
         let mut n = 0;
         let mut shift = 0;
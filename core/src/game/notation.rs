@@ -0,0 +1,114 @@
+//! Turn notation.
+
+use super::*;
+use std::fmt::Write;
+
+/// Formats the move as in turn notation, for example `(1, 0) (0, 1)`.
+impl fmt::Display for Move {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match *self {
+            Self::Place(p1, None) => write!(f, "{p1}"),
+            Self::Place(p1, Some(p2)) => write!(f, "{p1} {p2}"),
+            Self::Pass => write!(f, "pass"),
+            Self::Win(p, dir) => write!(f, "win {p} {dir}"),
+            Self::Draw => write!(f, "draw"),
+            Self::Resign(stone) => write!(f, "resign {stone}"),
+        }
+    }
+}
+
+impl Record {
+    /// Formats the past moves in turn notation, for example
+    /// `1. (0, 0) 2. (1, 0) (0, 1) 3. pass`.
+    ///
+    /// Each move is preceded by its number starting from 1. Stones are
+    /// written as coordinates, and other moves as `pass`, `draw`,
+    /// `resign <stone>` or `win <point> <direction>`.
+    #[must_use]
+    pub fn to_notation(&self) -> String {
+        let mut s = String::new();
+        for (i, &mov) in self.moves[..self.index].iter().enumerate() {
+            if i > 0 {
+                s.push(' ');
+            }
+            _ = write!(s, "{}. {mov}", i + 1);
+        }
+        s
+    }
+
+    /// Parses a record from turn notation as formatted by `to_notation`,
+    /// ignoring case and whitespace between tokens.
+    ///
+    /// # Errors
+    ///
+    /// Returns the number of the first turn that is malformed or illegal,
+    /// such as one placing two stones in the first move.
+    pub fn from_notation(s: &str) -> Result<Self, usize> {
+        let mut record = Self::new();
+        let mut rest = s.trim_start();
+
+        while !rest.is_empty() {
+            let turn = record.index + 1;
+            let (mov, next) = parse_turn(rest, turn).ok_or(turn)?;
+            if !record.make_move(mov) {
+                return Err(turn);
+            }
+            rest = next.trim_start();
+        }
+        Ok(record)
+    }
+}
+
+/// Parses the turn with the given number, returning the move and the rest.
+fn parse_turn(s: &str, turn: usize) -> Option<(Move, &str)> {
+    let s = s.strip_prefix(&*format!("{turn}."))?.trim_start();
+
+    let (mov, rest) = if s.starts_with('(') {
+        let (p1, s) = parse_point(s)?;
+        match parse_point(s.trim_start()) {
+            Some((p2, s)) => (Move::Place(p1, Some(p2)), s),
+            None => (Move::Place(p1, None), s),
+        }
+    } else {
+        let (word, s) = parse_word(s)?;
+        match &*word.to_ascii_lowercase() {
+            "pass" => (Move::Pass, s),
+            "draw" => (Move::Draw, s),
+            "resign" => {
+                let (word, s) = parse_word(s.trim_start())?;
+                let stone = [Stone::Black, Stone::White]
+                    .into_iter()
+                    .find(|stone| stone.to_string().eq_ignore_ascii_case(word))?;
+                (Move::Resign(stone), s)
+            }
+            "win" => {
+                let (p, s) = parse_point(s.trim_start())?;
+                let (word, s) = parse_word(s.trim_start())?;
+                let dir = (0..8)
+                    .filter_map(Direction::from_u8)
+                    .find(|dir| dir.to_string().eq_ignore_ascii_case(word))?;
+                (Move::Win(p, dir), s)
+            }
+            _ => return None,
+        }
+    };
+
+    // The next turn must be separated by whitespace.
+    (rest.is_empty() || rest.starts_with(char::is_whitespace)).then_some((mov, rest))
+}
+
+/// Parses a point like `(1, -2)`, returning it and the rest.
+fn parse_point(s: &str) -> Option<(Point, &str)> {
+    let (inner, rest) = s.strip_prefix('(')?.split_once(')')?;
+    let (x, y) = inner.split_once(',')?;
+    let p = Point::new(x.trim().parse().ok()?, y.trim().parse().ok()?);
+    Some((p, rest))
+}
+
+/// Parses a word of ASCII letters, returning it and the rest.
+fn parse_word(s: &str) -> Option<(&str, &str)> {
+    let end = s
+        .find(|c: char| !c.is_ascii_alphabetic())
+        .unwrap_or(s.len());
+    (end > 0).then(|| s.split_at(end))
+}
@@ -0,0 +1,311 @@
+//! Threat detection.
+
+use super::*;
+use std::collections::HashSet;
+
+/// The length of a winning row.
+const ROW_LEN: i16 = 6;
+
+/// A window of six consecutive cells in a line, with at least four stones
+/// of a player and no stones of the opponent.
+///
+/// The player can complete a winning row in the window within one turn,
+/// so the opponent has to place a stone in it to block the threat.
+#[derive(Clone, Copy, Debug, Eq, PartialEq)]
+pub struct Threat {
+    /// The first cell of the window.
+    pub start: Point,
+    /// The canonical direction from the first cell to the last.
+    pub dir: Direction,
+    /// The empty cells in the window.
+    pub empty: (Point, Option<Point>),
+}
+
+impl Threat {
+    /// Tests if placing a stone at `p` blocks the threat.
+    #[must_use]
+    pub fn is_blocked_by(self, p: Point) -> bool {
+        self.empty.0 == p || self.empty.1 == Some(p)
+    }
+}
+
+/// The reason for a hinted move.
+#[derive(Clone, Copy, Debug, Eq, PartialEq)]
+pub enum Hint {
+    /// The move completes a winning row.
+    Win,
+    /// The move blocks every threat posed by the opponent.
+    Block,
+    /// The move extends the longest open run.
+    Extend,
+}
+
+impl fmt::Display for Hint {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(match self {
+            Self::Win => "completes six",
+            Self::Block => "blocks the opponent's threats",
+            Self::Extend => "extends your longest line",
+        })
+    }
+}
+
+impl Record {
+    /// Returns the threat in the given window (if any) posed by `stone`.
+    fn threat_at(&self, start: Point, dir: Direction, stone: Stone) -> Option<Threat> {
+        let mut empty = [Point::ZERO; 2];
+        let mut empty_len = 0;
+
+        for i in 0..ROW_LEN {
+            let p = start + dir.offset(i);
+            match self.stone_at(p) {
+                Some(s) if s == stone => {}
+                Some(_) => return None,
+                None if !p.is_placeable() || empty_len == 2 => return None,
+                None => {
+                    empty[empty_len] = p;
+                    empty_len += 1;
+                }
+            }
+        }
+
+        let empty = match empty_len {
+            1 => (empty[0], None),
+            2 => (empty[0], Some(empty[1])),
+            // Already a winning row.
+            _ => return None,
+        };
+        Some(Threat { start, dir, empty })
+    }
+
+    /// Returns all threats posed by `stone`, ordered by the index of the
+    /// first cell and then by direction.
+    #[must_use]
+    pub fn threats(&self, stone: Stone) -> Vec<Threat> {
+        let mut visited = HashSet::new();
+        let mut threats = vec![];
+
+        for (&p, &s) in &self.map {
+            if s != stone {
+                continue;
+            }
+            for dir in Direction::VALUES_CANONICAL {
+                for i in 0..ROW_LEN {
+                    let start = p + dir.offset(-i);
+                    if !visited.insert((start, dir)) {
+                        continue;
+                    }
+                    if let Some(threat) = self.threat_at(start, dir, stone) {
+                        threats.push(threat);
+                    }
+                }
+            }
+        }
+
+        threats.sort_by_key(|t| (t.start.index(), t.dir as u8));
+        threats
+    }
+
+    /// Tests if any of the stones at `positions` is part of a threat posed
+    /// by its owner, checking only the windows through `positions`.
+    ///
+    /// This tells cheaply whether a move just made poses a threat.
+    #[must_use]
+    pub fn forms_threat(&self, positions: &[Point]) -> bool {
+        positions.iter().any(|&p| {
+            let Some(stone) = self.stone_at(p) else {
+                return false;
+            };
+            Direction::VALUES_CANONICAL.into_iter().any(|dir| {
+                (0..ROW_LEN).any(|i| self.threat_at(p + dir.offset(-i), dir, stone).is_some())
+            })
+        })
+    }
+
+    /// Counts the open runs of `stone` by length.
+    ///
+    /// A run is a maximal line of consecutive stones, and is open if
+    /// the cells at both of its ends are empty. Only runs of length
+    /// from 2 to 5 are counted, each scanned once from its first stone.
+    #[must_use]
+    pub fn line_counts(&self, stone: Stone) -> BTreeMap<usize, usize> {
+        let is_open = |p: Point| p.is_placeable() && self.stone_at(p).is_none();
+        let mut counts = BTreeMap::new();
+
+        for (&p, &s) in &self.map {
+            if s != stone {
+                continue;
+            }
+            for dir in Direction::VALUES_CANONICAL {
+                let before = p + dir.offset(-1);
+                if self.stone_at(before) == Some(stone) {
+                    // Not the first stone of the run.
+                    continue;
+                }
+
+                let len = 1 + self.scan(p, dir, stone).count();
+                if (2..ROW_LEN as usize).contains(&len)
+                    && is_open(before)
+                    && is_open(p + dir.offset(len as i16))
+                {
+                    *counts.entry(len).or_insert(0) += 1;
+                }
+            }
+        }
+        counts
+    }
+
+    /// Returns the moves with which `stone` blocks every threat posed by the
+    /// opponent, using as few stones as possible.
+    ///
+    /// A 1-stone move means that one stone suffices to block all threats,
+    /// leaving the other stone free. Returns an empty vector if there are
+    /// no threats to block, or `None` if two stones are not enough, in
+    /// which case the opponent is sure to win in their next turn.
+    #[must_use]
+    pub fn blocking_moves(&self, stone: Stone) -> Option<Vec<Move>> {
+        let threats = self.threats(stone.opposite());
+        if threats.is_empty() {
+            return Some(vec![]);
+        }
+
+        let mut cells: Vec<_> = threats
+            .iter()
+            .flat_map(|t| iter::once(t.empty.0).chain(t.empty.1))
+            .collect();
+        cells.sort_by_key(|p| p.index());
+        cells.dedup();
+
+        let blocks_all = |ps: &[Point]| {
+            threats
+                .iter()
+                .all(|t| ps.iter().any(|&p| t.is_blocked_by(p)))
+        };
+
+        let singles: Vec<_> = cells
+            .iter()
+            .filter(|&&p| blocks_all(&[p]))
+            .map(|&p| Move::Place(p, None))
+            .collect();
+        if !singles.is_empty() {
+            return Some(singles);
+        }
+
+        let mut pairs = vec![];
+        for (i, &p1) in cells.iter().enumerate() {
+            for &p2 in &cells[i + 1..] {
+                if blocks_all(&[p1, p2]) {
+                    pairs.push(Move::Place(p1, Some(p2)));
+                }
+            }
+        }
+        (!pairs.is_empty()).then_some(pairs)
+    }
+
+    /// Suggests a move for `stone` and the reason for it, preferring to
+    /// complete a winning row, then to block the opponent's threats, and
+    /// then to extend the longest open run of `stone`.
+    ///
+    /// This is a heuristic for hints, not a search. Returns `None` if the
+    /// board is empty, the game is ended, or no rule applies.
+    #[must_use]
+    pub fn hint(&self, stone: Stone) -> Option<(Move, Hint)> {
+        if !self.has_past() || self.is_ended() {
+            return None;
+        }
+
+        if let Some(threat) = self.threats(stone).first() {
+            let (p1, p2) = threat.empty;
+            return Some((Move::Place(p1, p2), Hint::Win));
+        }
+        // Fall through if the threats cannot be blocked.
+        if let Some(&mov) = self.blocking_moves(stone).unwrap_or_default().first() {
+            return Some((mov, Hint::Block));
+        }
+
+        let is_open = |p: Point| p.is_placeable() && self.stone_at(p).is_none();
+        let mut best = None::<(usize, Move)>;
+
+        for (p, s) in self.stones() {
+            if s != stone {
+                continue;
+            }
+            for dir in Direction::VALUES_CANONICAL {
+                let run = self.run_at(p, dir)?;
+                // Consider each run once, from its start.
+                if run.start != p || best.is_some_and(|(len, _)| len >= run.len) {
+                    continue;
+                }
+
+                let before = run.start + dir.offset(-1);
+                let after = run.end + dir.offset(1);
+                let mov = match (run.open_start, run.open_end) {
+                    (true, true) => Move::Place(before, Some(after)),
+                    (true, false) => {
+                        let next = before + dir.offset(-1);
+                        Move::Place(before, is_open(next).then_some(next))
+                    }
+                    (false, true) => {
+                        let next = after + dir.offset(1);
+                        Move::Place(after, is_open(next).then_some(next))
+                    }
+                    (false, false) => continue,
+                };
+                best = Some((run.len, mov));
+            }
+        }
+        best.map(|(_, mov)| (mov, Hint::Extend))
+    }
+
+    /// Tests if the position is quiet, that is, neither player has a
+    /// winning row on the board or a threat the opponent must answer.
+    ///
+    /// A search can stop extending forcing lines at a quiet position.
+    #[must_use]
+    pub fn is_quiet(&self) -> bool {
+        self.stones()
+            .all(|(p, _)| self.find_winning_row(p).is_none())
+            && self.threats(Stone::Black).is_empty()
+            && self.threats(Stone::White).is_empty()
+    }
+
+    /// Tests if placing `stone` at each of `positions` creates a fork,
+    /// that is, threats the opponent cannot all block in their next turn.
+    ///
+    /// As the opponent places two stones per turn, the threats must take
+    /// at least three stones to block.
+    ///
+    /// Returns `None` if `positions` cannot all be placed at, see
+    /// [`Self::can_place_all`].
+    pub fn creates_fork(&mut self, stone: Stone, positions: &[Point]) -> Option<bool> {
+        if !self.can_place_all(positions) {
+            return None;
+        }
+        Some(self.with_temp_placements(stone, positions, |record| {
+            record.blocking_moves(stone.opposite()).is_none()
+        }))
+    }
+
+    /// Tests if placing `stone` at each of `positions` lets the opponent
+    /// win in their next turn, that is, leaves any threat posed by the
+    /// opponent unblocked.
+    ///
+    /// Returns `None` if `positions` cannot all be placed at, see
+    /// [`Self::can_place_all`].
+    pub fn opponent_wins_after(&mut self, stone: Stone, positions: &[Point]) -> Option<bool> {
+        if !self.can_place_all(positions) {
+            return None;
+        }
+        Some(self.with_temp_placements(stone, positions, |record| {
+            !record.threats(stone.opposite()).is_empty()
+        }))
+    }
+
+    /// Tests if stones can be placed at each of `positions` at once,
+    /// that is, if they are placeable, empty and distinct.
+    fn can_place_all(&self, positions: &[Point]) -> bool {
+        positions.iter().enumerate().all(|(i, &p)| {
+            p.is_placeable() && self.stone_at(p).is_none() && !positions[..i].contains(&p)
+        })
+    }
+}
@@ -90,37 +90,63 @@ const BASE62_LUT: &[i8] = &{
     out
 };
 
+fn decode_base62(buf: &[u8]) -> Option<i64> {
+    if buf.len() != 11 {
+        return None;
+    }
+
+    let mut n = 0u64;
+    for &x in buf {
+        let x = BASE62_LUT[x as usize];
+        if x < 0 {
+            return None;
+        }
+        n = n.checked_mul(62)?.checked_add(x as u64)?;
+    }
+    Some(n as i64)
+}
+
+fn fmt_base62(n: i64, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+    let mut n = n as u64;
+    let mut buf = [b'0'; 11];
+    let mut i = 11;
+    while n > 0 {
+        i -= 1;
+        buf[i] = BASE62_ALPHABET[(n % 62) as usize];
+        n /= 62;
+    }
+    f.write_str(str::from_utf8(&buf).unwrap())
+}
+
 impl GameId {
     /// Decodes a Base62-encoded game ID.
     #[must_use]
     pub fn from_base62(buf: &[u8]) -> Option<Self> {
-        if buf.len() != 11 {
-            return None;
-        }
-
-        let mut n = 0u64;
-        for &x in buf {
-            let x = BASE62_LUT[x as usize];
-            if x < 0 {
-                return None;
-            }
-            n = n.checked_mul(62)?.checked_add(x as u64)?;
-        }
-        Some(Self(n as i64))
+        decode_base62(buf).map(Self)
     }
 }
 
 impl fmt::Display for GameId {
     fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
-        let mut n = self.0 as u64;
-        let mut buf = [b'0'; 11];
-        let mut i = 11;
-        while n > 0 {
-            i -= 1;
-            buf[i] = BASE62_ALPHABET[(n % 62) as usize];
-            n /= 62;
-        }
-        f.write_str(str::from_utf8(&buf).unwrap())
+        fmt_base62(self.0, f)
+    }
+}
+
+/// A token to watch a game with, which does not reveal the game ID.
+#[derive(Clone, Copy, Debug, Eq, Hash, PartialEq)]
+pub struct WatchToken(pub i64);
+
+impl WatchToken {
+    /// Decodes a Base62-encoded watch token.
+    #[must_use]
+    pub fn from_base62(buf: &[u8]) -> Option<Self> {
+        decode_base62(buf).map(Self)
+    }
+}
+
+impl fmt::Display for WatchToken {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        fmt_base62(self.0, f)
     }
 }
 
@@ -147,6 +173,9 @@ pub trait Message: Sized {
 pub struct GameOptions {
     /// Whether the stones are swapped.
     pub swapped: bool,
+    /// Whether only rows of exactly six stones win, so that overlines
+    /// (rows of seven or more stones) do not.
+    pub exact_six: bool,
 }
 
 impl GameOptions {
@@ -165,14 +194,29 @@ impl GameOptions {
     }
 }
 
+const OPTION_FLAG_SWAPPED: u8 = 1;
+const OPTION_FLAG_EXACT_SIX: u8 = 2;
+
 impl Message for GameOptions {
     fn encode(self, buf: &mut Vec<u8>) {
-        buf.put_u8(self.swapped as u8);
+        let mut flags = 0;
+        if self.swapped {
+            flags |= OPTION_FLAG_SWAPPED;
+        }
+        if self.exact_six {
+            flags |= OPTION_FLAG_EXACT_SIX;
+        }
+        buf.put_u8(flags);
     }
 
     fn decode(buf: &mut &[u8]) -> Option<Self> {
+        let flags = buf.try_get_u8().ok()?;
+        if flags & !(OPTION_FLAG_SWAPPED | OPTION_FLAG_EXACT_SIX) != 0 {
+            return None;
+        }
         Some(Self {
-            swapped: buf.try_get_u8().ok()?.try_into().ok()?,
+            swapped: flags & OPTION_FLAG_SWAPPED != 0,
+            exact_six: flags & OPTION_FLAG_EXACT_SIX != 0,
         })
     }
 }
@@ -210,8 +254,32 @@ impl Message for Request {
     }
 }
 
+/// The maximum length of a chat message in bytes.
+pub const MAX_CHAT_LEN: usize = 256;
+
+/// Tests if the text can be sent in a chat message,
+/// that is, it is non-empty and at most `MAX_CHAT_LEN` bytes long.
+#[must_use]
+pub fn is_valid_chat(text: &str) -> bool {
+    !text.is_empty() && text.len() <= MAX_CHAT_LEN
+}
+
+fn encode_chat(text: &str, buf: &mut Vec<u8>) {
+    assert!(is_valid_chat(text), "invalid chat message");
+    buf.put_slice(text.as_bytes());
+}
+
+fn decode_chat(buf: &mut &[u8]) -> Option<Box<str>> {
+    if buf.is_empty() || buf.len() > MAX_CHAT_LEN {
+        return None;
+    }
+    let text = str::from_utf8(buf).ok()?.into();
+    buf.advance(buf.len());
+    Some(text)
+}
+
 /// A client message.
-#[derive(Clone, Copy, Debug, EnumDiscriminants)]
+#[derive(Clone, Debug, EnumDiscriminants)]
 #[strum_discriminants(derive(FromRepr), name(ClientMessageKind), repr(u8), vis(pub(self)))]
 pub enum ClientMessage {
     /// Requests to start a new game.
@@ -234,6 +302,30 @@ pub enum ClientMessage {
     AcceptRequest,
     /// Declines the opponent's request.
     DeclineRequest,
+    /// Sends a chat message.
+    ///
+    /// Encoding panics if the text is not valid as per `is_valid_chat`.
+    Chat(Box<str>),
+    /// Requests to start a new game with the next player to match.
+    Match,
+    /// Requests to place one or two stones once it is the player's turn,
+    /// replacing any previous such request.
+    Premove(Point, Option<Point>),
+    /// Requests to watch the game the token was shared for,
+    /// without a seat to take.
+    Watch(WatchToken),
+    /// Requests the watch token of the game, sharing a new one if none.
+    ///
+    /// Only the host may share the game for watching.
+    GetWatchToken,
+    /// Revokes the watch token of the game, closing the connections
+    /// of anyone watching with it.
+    ///
+    /// Only the host may revoke it.
+    RevokeWatchToken,
+    /// Acknowledges the epoch of the game, after which messages
+    /// are checked against the game in that epoch.
+    AckEpoch(u32),
 }
 
 impl Message for ClientMessage {
@@ -242,8 +334,9 @@ impl Message for ClientMessage {
         match self {
             Self::Start(options) => options.encode(buf),
             Self::Join(game_id) => buf.put_i64(game_id.0),
+            Self::Watch(token) => buf.put_i64(token.0),
             Self::Authenticate(hash) => buf.put_i64(hash),
-            Self::Place(p1, p2) => {
+            Self::Place(p1, p2) | Self::Premove(p1, p2) => {
                 for p in iter::once(p1).chain(p2) {
                     p.encode(buf);
                 }
@@ -256,24 +349,33 @@ impl Message for ClientMessage {
             Self::Resign => {}
             Self::Request(req) => req.encode(buf),
             Self::AcceptRequest | Self::DeclineRequest => {}
+            Self::Chat(text) => encode_chat(&text, buf),
+            Self::Match | Self::GetWatchToken | Self::RevokeWatchToken => {}
+            Self::AckEpoch(epoch) => buf.put_u32(epoch),
         }
     }
 
     fn decode(buf: &mut &[u8]) -> Option<Self> {
         use ClientMessageKind as Kind;
 
-        let msg = match Kind::from_repr(buf.try_get_u8().ok()?)? {
+        let kind = Kind::from_repr(buf.try_get_u8().ok()?)?;
+        let msg = match kind {
             Kind::Start => Self::Start(GameOptions::decode(buf)?),
             Kind::Join => Self::Join(GameId(buf.try_get_i64().ok()?)),
+            Kind::Watch => Self::Watch(WatchToken(buf.try_get_i64().ok()?)),
             Kind::Authenticate => Self::Authenticate(buf.try_get_i64().ok()?),
-            Kind::Place => {
+            Kind::Place | Kind::Premove => {
                 let p1 = Point::decode(buf)?;
                 let p2 = if buf.has_remaining() {
                     Some(Point::decode(buf)?)
                 } else {
                     None
                 };
-                Self::Place(p1, p2)
+                if kind == Kind::Place {
+                    Self::Place(p1, p2)
+                } else {
+                    Self::Premove(p1, p2)
+                }
             }
             Kind::Pass => Self::Pass,
             Kind::ClaimWin => Self::ClaimWin(
@@ -284,11 +386,58 @@ impl Message for ClientMessage {
             Kind::Request => Self::Request(Request::decode(buf)?),
             Kind::AcceptRequest => Self::AcceptRequest,
             Kind::DeclineRequest => Self::DeclineRequest,
+            Kind::Chat => Self::Chat(decode_chat(buf)?),
+            Kind::Match => Self::Match,
+            Kind::GetWatchToken => Self::GetWatchToken,
+            Kind::RevokeWatchToken => Self::RevokeWatchToken,
+            Kind::AckEpoch => Self::AckEpoch(buf.try_get_u32().ok()?),
         };
         (!buf.has_remaining()).then_some(msg)
     }
 }
 
+/// The reason a player's message was rejected.
+#[derive(Clone, Copy, Debug, Eq, PartialEq)]
+pub enum Rejection {
+    /// Not both seats of the game are taken yet.
+    SeatsOpen = 0,
+    /// The message is not for playing the game.
+    UnexpectedMessage = 1,
+    /// It is not the player's turn.
+    NotTheirTurn = 2,
+    /// A position to place a stone at is occupied.
+    Occupied = 3,
+    /// The move is illegal.
+    IllegalMove = 4,
+    /// The player has already made a request.
+    DuplicateRequest = 5,
+    /// There are no moves in the past to retract.
+    NoPastMove = 6,
+    /// The opponent has made no request.
+    NoRequest = 7,
+    /// The message was sent before the client acknowledged the latest reset.
+    StaleEpoch = 8,
+}
+
+impl Rejection {
+    fn from_u8(n: u8) -> Option<Self> {
+        Some(match n {
+            0 => Self::SeatsOpen,
+            1 => Self::UnexpectedMessage,
+            2 => Self::NotTheirTurn,
+            3 => Self::Occupied,
+            4 => Self::IllegalMove,
+            5 => Self::DuplicateRequest,
+            6 => Self::NoPastMove,
+            7 => Self::NoRequest,
+            8 => Self::StaleEpoch,
+            _ => return None,
+        })
+    }
+}
+
+const SENDER_SPECTATOR: u8 = 2;
+
 /// A server message.
 #[derive(Clone, EnumDiscriminants)]
 #[strum_discriminants(derive(FromRepr), name(ServerMessageKind), repr(u8), vis(pub(self)))]
@@ -311,6 +460,21 @@ pub enum ServerMessage {
     AcceptRequest(Player),
     /// A player declined the opponent's request.
     DeclineRequest(Player),
+    /// A chat message was sent by a player, or by a spectator if `None`.
+    ///
+    /// Encoding panics if the text is not valid as per `is_valid_chat`.
+    Chat(Option<Player>, Box<str>),
+    /// Both seats of the game are taken, so it can be played from now on.
+    SeatsFilled,
+    /// A player's premove failed when it became their turn.
+    PremoveFailed(Player),
+    /// The user's message was rejected.
+    Rejected(Rejection),
+    /// The watch token of the game, sent to the host on request.
+    WatchToken(WatchToken),
+    /// The epoch of the game, which is increased on every reset
+    /// and must be acknowledged by the client.
+    Epoch(u32),
 }
 
 impl Message for ServerMessage {
@@ -327,7 +491,17 @@ impl Message for ServerMessage {
                 buf.put_u8(player as u8);
                 req.encode(buf);
             }
-            Self::AcceptRequest(player) | Self::DeclineRequest(player) => buf.put_u8(player as u8),
+            Self::AcceptRequest(player)
+            | Self::DeclineRequest(player)
+            | Self::PremoveFailed(player) => buf.put_u8(player as u8),
+            Self::Chat(sender, text) => {
+                buf.put_u8(sender.map_or(SENDER_SPECTATOR, |player| player as u8));
+                encode_chat(&text, buf);
+            }
+            Self::SeatsFilled => {}
+            Self::Rejected(reason) => buf.put_u8(reason as u8),
+            Self::WatchToken(token) => buf.put_i64(token.0),
+            Self::Epoch(epoch) => buf.put_u32(epoch),
         }
     }
 
@@ -347,6 +521,18 @@ impl Message for ServerMessage {
             ),
             Kind::AcceptRequest => Self::AcceptRequest(Player::from_u8(buf.try_get_u8().ok()?)?),
             Kind::DeclineRequest => Self::DeclineRequest(Player::from_u8(buf.try_get_u8().ok()?)?),
+            Kind::Chat => {
+                let sender = match buf.try_get_u8().ok()? {
+                    SENDER_SPECTATOR => None,
+                    n => Some(Player::from_u8(n)?),
+                };
+                Self::Chat(sender, decode_chat(buf)?)
+            }
+            Kind::SeatsFilled => Self::SeatsFilled,
+            Kind::PremoveFailed => Self::PremoveFailed(Player::from_u8(buf.try_get_u8().ok()?)?),
+            Kind::Rejected => Self::Rejected(Rejection::from_u8(buf.try_get_u8().ok()?)?),
+            Kind::WatchToken => Self::WatchToken(WatchToken(buf.try_get_i64().ok()?)),
+            Kind::Epoch => Self::Epoch(buf.try_get_u32().ok()?),
         };
         (!buf.has_remaining()).then_some(msg)
     }
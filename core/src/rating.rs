@@ -0,0 +1,48 @@
+//! Elo ratings.
+
+use crate::game::{Stone, Summary};
+
+/// The Elo rating system.
+#[derive(Clone, Copy, Debug, PartialEq)]
+pub struct Elo {
+    /// The maximum change of a rating in a single game.
+    pub k_factor: f64,
+}
+
+impl Default for Elo {
+    fn default() -> Self {
+        Self { k_factor: 32.0 }
+    }
+}
+
+impl Elo {
+    /// Returns the expected score of a player rated `a` against one rated `b`.
+    #[must_use]
+    pub fn expected_score(a: f64, b: f64) -> f64 {
+        1.0 / (1.0 + 10f64.powf((b - a) / 400.0))
+    }
+
+    /// Updates the ratings of two players given the score of the first,
+    /// which is 1 for a win, 0.5 for a draw and 0 for a loss.
+    ///
+    /// Returns the new ratings.
+    #[must_use]
+    pub fn update(self, a: f64, b: f64, score: f64) -> (f64, f64) {
+        let delta = self.k_factor * (score - Self::expected_score(a, b));
+        (a + delta, b - delta)
+    }
+
+    /// Updates the ratings of the Black and White players given the
+    /// summary of a game between them.
+    ///
+    /// Returns the new ratings.
+    #[must_use]
+    pub fn update_with_summary(self, black: f64, white: f64, summary: &Summary) -> (f64, f64) {
+        let score = match summary.winner {
+            Some(Stone::Black) => 1.0,
+            Some(Stone::White) => 0.0,
+            None => 0.5,
+        };
+        self.update(black, white, score)
+    }
+}
@@ -0,0 +1,70 @@
+#![allow(missing_docs)]
+
+use std::fs;
+
+use base64::prelude::*;
+use c6ol_core::game::{Move, Point, Record, RecordEncodingScheme, Stone};
+
+fn cases() -> Vec<(&'static str, Record)> {
+    let mut single = Record::new();
+    assert!(single.make_move(Move::Place(Point::ZERO, None)));
+
+    let mut two_stones = single.clone();
+    assert!(two_stones.make_move(Move::Place(Point::new(1, 0), Some(Point::new(-1, 1)))));
+
+    let mut future = two_stones.clone();
+    assert!(future.make_move(Move::Place(Point::new(2, 2), Some(Point::new(3, -1)))));
+    assert!(future.make_move(Move::Pass));
+    assert!(future.make_move(Move::Resign(Stone::Black)));
+    future.undo_move();
+    future.undo_move();
+
+    vec![
+        ("empty", Record::new()),
+        ("single", single),
+        ("two_stones", two_stones),
+        ("future", future),
+    ]
+}
+
+fn schemes() -> [(&'static str, RecordEncodingScheme); 2] {
+    [
+        ("all", RecordEncodingScheme::all()),
+        ("past", RecordEncodingScheme::past()),
+    ]
+}
+
+/// Checks records against the fixtures in `records/golden.txt`.
+///
+/// Each line has the form `<case>/<scheme> <base64>`. Any change
+/// to the encoding must update the fixtures deliberately.
+#[test]
+fn golden() {
+    let fixtures = fs::read_to_string("records/golden.txt").unwrap();
+    let mut lines = fixtures.lines();
+
+    for (case, record) in cases() {
+        for (scheme_name, scheme) in schemes() {
+            let name = format!("{case}/{scheme_name}");
+            let (fixture_name, fixture) = lines.next().unwrap().split_once(' ').unwrap();
+            assert_eq!(fixture_name, name);
+
+            let bytes = record.encode_to_vec(scheme);
+            assert_eq!(BASE64_URL_SAFE_NO_PAD.encode(&bytes), fixture, "{name}");
+
+            let bytes = BASE64_URL_SAFE_NO_PAD.decode(fixture).unwrap();
+            let decoded = Record::decode(&mut &bytes[..]).unwrap();
+            assert_eq!(decoded.move_index(), record.move_index(), "{name}");
+            if scheme.all {
+                assert_eq!(decoded, record, "{name}");
+            } else {
+                assert_eq!(
+                    decoded.moves(),
+                    &record.moves()[..record.move_index()],
+                    "{name}"
+                );
+            }
+        }
+    }
+    assert_eq!(lines.next(), None);
+}
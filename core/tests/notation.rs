@@ -0,0 +1,67 @@
+#![allow(missing_docs)]
+
+use c6ol_core::game::{Direction, Move, Point, Record, Stone};
+
+#[test]
+fn roundtrip() {
+    let mut record = Record::new();
+    assert_eq!(record.to_notation(), "");
+    assert_eq!(Record::from_notation(""), Ok(Record::new()));
+
+    let moves = [
+        Move::Place(Point::ZERO, None),
+        Move::Place(Point::new(1, 0), Some(Point::new(-1, 3))),
+        Move::Pass,
+        Move::Resign(Stone::White),
+    ];
+    assert_eq!(record.make_moves(&moves), Ok(()));
+
+    let text = record.to_notation();
+    assert_eq!(text, "1. (0, 0) 2. (1, 0) (-1, 3) 3. pass 4. resign White");
+    assert_eq!(Record::from_notation(&text), Ok(record.clone()));
+
+    // Only past moves are formatted.
+    record.undo_move();
+    assert_eq!(record.to_notation(), "1. (0, 0) 2. (1, 0) (-1, 3) 3. pass");
+}
+
+#[test]
+fn parse() {
+    let record = Record::from_notation(" 1.(0,0)\n2. ( 2,0 )(-1, 3)  3. DRAW ").unwrap();
+    assert_eq!(
+        record.moves(),
+        [
+            Move::Place(Point::ZERO, None),
+            Move::Place(Point::new(2, 0), Some(Point::new(-1, 3))),
+            Move::Draw,
+        ]
+    );
+
+    let mut text = String::from("1. (0, 5)");
+    for i in 0..3 {
+        let (x1, x2) = (2 * i, 2 * i + 1);
+        text += &format!(" {}. ({x1}, 0) ({x2}, 0)", 2 * i + 2);
+        text += &format!(" {}. ({x1}, 6) ({x2}, 6)", 2 * i + 3);
+    }
+    text += " 8. win (0, 0) east";
+    let record = Record::from_notation(&text).unwrap();
+    assert_eq!(
+        record.prev_move(),
+        Some(Move::Win(Point::ZERO, Direction::East))
+    );
+}
+
+#[test]
+fn parse_errors() {
+    // The first turn places one stone only.
+    assert_eq!(Record::from_notation("1. (0, 0) (1, 1)"), Err(1));
+    // Turns are numbered in order.
+    assert_eq!(Record::from_notation("1. (0, 0) 3. (1, 0)"), Err(2));
+    // Positions are not placed twice.
+    assert_eq!(Record::from_notation("1. (0, 0) 2. (1, 0) (0, 0)"), Err(2));
+    assert_eq!(Record::from_notation("1. (0, 0) 2. jump"), Err(2));
+    assert_eq!(Record::from_notation("1. (0, 0) 2. resign Red"), Err(2));
+    assert_eq!(Record::from_notation("1. (0, 0)x"), Err(1));
+    assert_eq!(Record::from_notation("1. (0, 0"), Err(1));
+    assert_eq!(Record::from_notation("1. (0, 99999)"), Err(1));
+}
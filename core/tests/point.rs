@@ -0,0 +1,30 @@
+#![allow(missing_docs)]
+
+use c6ol_core::game::Point;
+
+#[test]
+fn label_roundtrip() {
+    for x in -50..50 {
+        for y in -50..50 {
+            let p = Point::new(x, y);
+            assert_eq!(Point::from_label(&p.label()), Some(p));
+        }
+    }
+
+    for p in [
+        Point::new(i16::MIN, i16::MIN),
+        Point::new(i16::MAX, i16::MIN),
+    ] {
+        assert_eq!(Point::from_label(&p.label()), Some(p));
+    }
+
+    assert_eq!(Point::ZERO.label(), "0");
+    assert_eq!(Point::from_label(&u32::MAX.to_string()), None);
+}
+
+#[test]
+fn label_malformed() {
+    for label in ["", "00", "01", "A", "1a-", " 1", "+1", "zzzzzzz"] {
+        assert_eq!(Point::from_label(label), None, "{label:?}");
+    }
+}
@@ -0,0 +1,24 @@
+#![allow(missing_docs)]
+
+use c6ol_core::protocol::{GameOptions, Message};
+
+#[test]
+fn game_options() {
+    for swapped in [false, true] {
+        for exact_six in [false, true] {
+            let options = GameOptions { swapped, exact_six };
+            let buf = options.encode_to_vec();
+            assert_eq!(GameOptions::decode(&mut &buf[..]), Some(options));
+        }
+    }
+
+    // Options encoded before the overline rule was added.
+    assert_eq!(
+        GameOptions::decode(&mut &[1][..]),
+        Some(GameOptions {
+            swapped: true,
+            exact_six: false,
+        })
+    );
+    assert_eq!(GameOptions::decode(&mut &[4][..]), None);
+}
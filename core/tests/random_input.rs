@@ -0,0 +1,63 @@
+#![allow(missing_docs)]
+
+use c6ol_core::{
+    game::{Record, RecordEncodingScheme},
+    protocol::{ClientMessage, Message, ServerMessage},
+};
+use rand::{prelude::*, rngs::ThreadRng};
+
+const ROUNDS: usize = 50_000;
+
+fn random_bytes(rng: &mut ThreadRng) -> Vec<u8> {
+    let len = rng.random_range(0..48);
+    let mut buf: Vec<_> = (0..len).map(|_| rng.random_range(0..=u8::MAX)).collect();
+
+    // Favor small leading bytes, which are valid schemes and message kinds.
+    if let Some(first) = buf.first_mut()
+        && rng.random_bool(0.5)
+    {
+        *first %= 16;
+    }
+    buf
+}
+
+#[test]
+fn decode_random_records() {
+    let mut rng = rand::rng();
+
+    for _ in 0..ROUNDS {
+        let buf = random_bytes(&mut rng);
+        let Some(record) = Record::decode(&mut &buf[..]) else {
+            continue;
+        };
+
+        assert_eq!(record.check_invariants(), Ok(()), "{buf:?}");
+        for (p, stone) in record.stones() {
+            assert_eq!(record.stone_at(p), Some(stone), "{buf:?}");
+        }
+
+        let encoded = record.encode_to_vec(RecordEncodingScheme::all());
+        assert_eq!(Record::decode(&mut &encoded[..]), Some(record), "{buf:?}");
+    }
+}
+
+#[test]
+fn decode_random_messages() {
+    let mut rng = rand::rng();
+
+    for _ in 0..ROUNDS {
+        let buf = random_bytes(&mut rng);
+
+        if let Some(msg) = ClientMessage::decode(&mut &buf[..]) {
+            let encoded = msg.encode_to_vec();
+            let msg = ClientMessage::decode(&mut &encoded[..]).expect("should decode");
+            assert_eq!(msg.encode_to_vec(), encoded, "{buf:?}");
+        }
+
+        if let Some(msg) = ServerMessage::decode(&mut &buf[..]) {
+            let encoded = msg.encode_to_vec();
+            let msg = ServerMessage::decode(&mut &encoded[..]).expect("should decode");
+            assert_eq!(msg.encode_to_vec(), encoded, "{buf:?}");
+        }
+    }
+}
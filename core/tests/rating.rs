@@ -0,0 +1,69 @@
+#![allow(missing_docs)]
+
+use c6ol_core::{
+    game::{Move, Stone, Summary},
+    rating::Elo,
+};
+
+fn summary(winner: Option<Stone>) -> Summary {
+    Summary {
+        winner,
+        ending: match winner {
+            Some(stone) => Move::Resign(stone.opposite()),
+            None => Move::Draw,
+        },
+        move_count: 10,
+    }
+}
+
+fn assert_close((a, b): (f64, f64), (c, d): (f64, f64)) {
+    assert!((a - c).abs() < 0.01 && (b - d).abs() < 0.01, "{a}, {b}");
+}
+
+#[test]
+fn equal_ratings() {
+    let elo = Elo::default();
+    let win = summary(Some(Stone::Black));
+    let loss = summary(Some(Stone::White));
+    let draw = summary(None);
+
+    assert_close(
+        elo.update_with_summary(1500.0, 1500.0, &win),
+        (1516.0, 1484.0),
+    );
+    assert_close(
+        elo.update_with_summary(1500.0, 1500.0, &loss),
+        (1484.0, 1516.0),
+    );
+    assert_close(
+        elo.update_with_summary(1500.0, 1500.0, &draw),
+        (1500.0, 1500.0),
+    );
+}
+
+#[test]
+fn unequal_ratings() {
+    let elo = Elo::default();
+    let win = summary(Some(Stone::Black));
+    let loss = summary(Some(Stone::White));
+    let draw = summary(None);
+
+    assert_close(
+        elo.update_with_summary(1600.0, 1400.0, &win),
+        (1607.69, 1392.31),
+    );
+    assert_close(
+        elo.update_with_summary(1600.0, 1400.0, &loss),
+        (1575.69, 1424.31),
+    );
+    assert_close(
+        elo.update_with_summary(1600.0, 1400.0, &draw),
+        (1591.69, 1408.31),
+    );
+
+    let elo = Elo { k_factor: 16.0 };
+    assert_close(
+        elo.update_with_summary(1400.0, 1600.0, &win),
+        (1412.16, 1587.84),
+    );
+}
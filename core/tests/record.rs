@@ -0,0 +1,584 @@
+#![allow(missing_docs)]
+
+use c6ol_core::{
+    game::{
+        Diff, Direction, MAX_COMMENT_LEN, Move, Point, Record, RecordEncodingScheme, Run, Stone,
+        Summary,
+    },
+    protocol::GameOptions,
+};
+
+#[test]
+fn nth_move() {
+    let mut record = Record::new();
+    assert_eq!(record.nth_move(0), None);
+
+    let m1 = Move::Place(Point::ZERO, None);
+    let m2 = Move::Place(Point::new(1, 0), Some(Point::new(0, 1)));
+    assert!(record.make_move(m1));
+    assert!(record.make_move(m2));
+
+    assert_eq!(record.nth_move(0), Some(m1));
+    assert_eq!(record.nth_move(1), Some(m2));
+    assert_eq!(record.nth_move(2), None);
+    assert_eq!(record.nth_move(usize::MAX), None);
+
+    // Future moves are still accessible.
+    record.undo_move();
+    assert_eq!(record.nth_move(1), Some(m2));
+}
+
+#[test]
+fn comments() {
+    let mut record = Record::new();
+    assert!(!record.set_comment(0, "No move yet."));
+
+    assert!(record.make_move(Move::Place(Point::ZERO, None)));
+    assert!(record.make_move(Move::Place(Point::new(1, 0), Some(Point::new(0, 1)))));
+    assert!(record.set_comment(0, "Center opening."));
+    assert!(record.set_comment(1, "Solid."));
+    assert!(!record.set_comment(1, &"x".repeat(MAX_COMMENT_LEN + 1)));
+
+    // Comments belong to moves, not the current position.
+    record.undo_move();
+    assert_eq!(record.comment(1), Some("Solid."));
+    record.redo_move();
+    assert_eq!(record.comment(1), Some("Solid."));
+
+    // But they go with the moves they belong to.
+    record.undo_move();
+    assert!(record.make_move(Move::Place(Point::new(-1, 0), Some(Point::new(0, -1)))));
+    assert_eq!(record.comment(0), Some("Center opening."));
+    assert_eq!(record.comment(1), None);
+
+    assert!(record.set_comment(0, ""));
+    assert_eq!(record.comment(0), None);
+}
+
+#[test]
+fn comments_roundtrip() {
+    let mut record = Record::new();
+    assert!(record.make_move(Move::Place(Point::ZERO, None)));
+    assert!(record.make_move(Move::Place(Point::new(1, 0), Some(Point::new(0, 1)))));
+    assert!(record.make_move(Move::Pass));
+    assert!(record.set_comment(0, "Center opening."));
+    assert!(record.set_comment(2, "Passing is rarely good. \u{1f914}"));
+    record.undo_move();
+
+    for delta in [false, true] {
+        let scheme = RecordEncodingScheme { all: true, delta };
+        let buf = record.encode_to_vec(scheme);
+        assert_eq!(Record::decode(&mut &buf[..]), Some(record.clone()));
+
+        // Comments on future moves are left out with the moves.
+        let scheme = RecordEncodingScheme { all: false, delta };
+        let buf = record.encode_to_vec(scheme);
+        let decoded = Record::decode(&mut &buf[..]).unwrap();
+        assert_eq!(decoded.comment(0), Some("Center opening."));
+        assert_eq!(decoded.comment(2), None);
+    }
+}
+
+#[test]
+fn snapshot() {
+    let mut record = Record::new();
+    assert!(record.make_move(Move::Place(Point::ZERO, None)));
+    assert!(record.make_move(Move::Place(Point::new(1, 0), Some(Point::new(0, 1)))));
+    record.undo_move();
+
+    let past = record.snapshot(0).unwrap();
+    assert_eq!(past.move_index(), 0);
+    assert_eq!(past.stone_at(Point::ZERO), None);
+
+    let future = record.snapshot(2).unwrap();
+    assert_eq!(future.move_index(), 2);
+    assert_eq!(future.stone_at(Point::new(1, 0)), Some(Stone::White));
+
+    assert_eq!(record.snapshot(3), None);
+
+    // The original is left intact.
+    assert_eq!(record.move_index(), 1);
+    assert_eq!(record.moves().len(), 2);
+}
+
+#[test]
+fn summary() {
+    let mut record = Record::new();
+    assert!(record.make_move(Move::Place(Point::ZERO, None)));
+    assert_eq!(record.summary(), None);
+
+    assert!(record.make_move(Move::Resign(Stone::White)));
+    assert_eq!(
+        record.summary(),
+        Some(Summary {
+            winner: Some(Stone::Black),
+            ending: Move::Resign(Stone::White),
+            move_count: 1,
+        })
+    );
+
+    record.undo_move();
+    assert!(record.make_move(Move::Draw));
+    assert_eq!(record.summary().unwrap().winner, None);
+
+    // White wins with a row along the x-axis.
+    let mut record = Record::new();
+    assert!(record.make_move(Move::Place(Point::new(0, 5), None)));
+    for i in 0..3 {
+        let (x1, x2) = (2 * i, 2 * i + 1);
+        assert!(record.make_move(Move::Place(Point::new(x1, 0), Some(Point::new(x2, 0)))));
+        assert!(record.make_move(Move::Place(Point::new(x1, 6), Some(Point::new(x2, 6)))));
+    }
+    let win = Move::Win(Point::ZERO, Direction::East);
+    assert!(record.make_move(win));
+    assert_eq!(
+        record.summary(),
+        Some(Summary {
+            winner: Some(Stone::White),
+            ending: win,
+            move_count: 7,
+        })
+    );
+    assert_eq!(record.winning_row(), Some((Point::ZERO, Point::new(5, 0))));
+
+    record.undo_move();
+    assert_eq!(record.winning_row(), None);
+}
+
+#[test]
+fn stones_within() {
+    let mut record = Record::new();
+    assert!(record.make_move(Move::Place(Point::ZERO, None)));
+    assert!(record.make_move(Move::Place(Point::new(2, 2), Some(Point::new(-3, 0)))));
+    assert!(record.make_move(Move::Place(Point::new(1, -1), Some(Point::new(9, 9)))));
+
+    assert_eq!(
+        record.stones_within(Point::ZERO, 2),
+        [
+            (Point::ZERO, Stone::Black),
+            (Point::new(1, -1), Stone::Black),
+            (Point::new(2, 2), Stone::White),
+        ]
+    );
+    assert_eq!(record.stones_within(Point::new(9, 9), 0).len(), 1);
+
+    record.undo_move();
+    assert_eq!(record.stones_within(Point::ZERO, 2).len(), 2);
+}
+
+#[test]
+fn stones_within_crowded() {
+    // Enough stones for small queries to probe positions instead.
+    let mut record = Record::new();
+    assert!(record.make_move(Move::Place(Point::new(20, 20), None)));
+    for i in 1..100 {
+        let (x, y) = (i % 13 - 6, i / 13 * 2 - 7);
+        assert!(record.make_move(Move::Place(Point::new(x, y), Some(Point::new(x, y + 1)))));
+    }
+
+    for (center, radius) in [
+        (Point::new(2, -3), 3),
+        (Point::new(-6, 8), 1),
+        (Point::ZERO, 9),
+    ] {
+        let mut expected: Vec<_> = record
+            .stones()
+            .filter(|&(p, _)| (p.x - center.x).abs().max((p.y - center.y).abs()) <= radius)
+            .collect();
+        expected.sort_by_key(|&(p, _)| p.index());
+        assert_eq!(record.stones_within(center, radius as u16), expected);
+    }
+
+    assert!(
+        record
+            .stones_within(Point::new(i16::MAX, i16::MIN), 2)
+            .is_empty()
+    );
+}
+
+#[test]
+fn stones() {
+    let mut record = Record::new();
+    assert_eq!(record.stones().next(), None);
+
+    assert!(record.make_move(Move::Place(Point::ZERO, None)));
+    assert!(record.make_move(Move::Place(Point::new(2, 2), Some(Point::new(-3, 0)))));
+    assert!(record.make_move(Move::Pass));
+    assert!(record.make_move(Move::Place(Point::new(1, -1), None)));
+
+    assert!(record.stones().eq([
+        (Point::ZERO, Stone::Black),
+        (Point::new(2, 2), Stone::White),
+        (Point::new(-3, 0), Stone::White),
+        (Point::new(1, -1), Stone::White),
+    ]));
+
+    // Iteration can stop early.
+    let first_white = record.stones().find(|&(_, stone)| stone == Stone::White);
+    assert_eq!(first_white, Some((Point::new(2, 2), Stone::White)));
+
+    record.undo_move();
+    assert_eq!(record.stones().count(), 3);
+}
+
+#[test]
+fn make_moves() {
+    let moves = [
+        Move::Place(Point::ZERO, None),
+        Move::Place(Point::new(1, 0), Some(Point::new(0, 1))),
+        Move::Place(Point::new(2, 0), Some(Point::new(1, 0))),
+        Move::Pass,
+    ];
+
+    // The third move places a stone on an occupied position.
+    let mut record = Record::new();
+    assert_eq!(record.make_moves(&moves), Err(2));
+    assert_eq!(record.moves(), &moves[..2]);
+    assert_eq!(record.move_index(), 2);
+
+    let mut replayed = Record::new();
+    assert_eq!(replayed.make_moves(record.moves()), Ok(()));
+    assert_eq!(replayed, record);
+
+    // Unlike replaying, jumping assumes the moves are valid.
+    record.undo_move();
+    assert!(record.jump(2));
+    assert_eq!(record, replayed);
+}
+
+#[test]
+fn make_moves_with_options() {
+    let p = Point::new;
+    let moves = [
+        Move::Place(p(0, 0), None),
+        Move::Place(p(0, 5), Some(p(1, 5))),
+        Move::Place(p(1, 0), Some(p(2, 0))),
+        Move::Place(p(0, 7), Some(p(1, 7))),
+        Move::Place(p(3, 0), Some(p(4, 0))),
+        Move::Place(p(0, 9), Some(p(1, 9))),
+        Move::Place(p(5, 0), Some(p(6, 0))),
+        Move::Win(p(0, 0), Direction::East),
+    ];
+
+    // A game won by an overline is only valid when overlines win.
+    let mut record = Record::new();
+    assert_eq!(
+        record.make_moves_with(GameOptions::default(), &moves),
+        Ok(())
+    );
+
+    let exact_six = GameOptions {
+        exact_six: true,
+        ..Default::default()
+    };
+    let mut record = Record::new();
+    assert_eq!(record.make_moves_with(exact_six, &moves), Err(7));
+    assert_eq!(record.move_index(), 7);
+}
+
+#[test]
+fn exact_winning_row() {
+    let p = |x| Point::new(x, 0);
+    let mut record = Record::new();
+    assert!(record.make_move(Move::Place(p(0), None)));
+    assert!(record.make_move(Move::Place(Point::new(0, 1), Some(Point::new(1, 1)))));
+    assert!(record.make_move(Move::Place(p(1), Some(p(2)))));
+    assert!(record.make_move(Move::Place(Point::new(2, 1), Some(Point::new(3, 1)))));
+
+    // A turn that passes through six to seven makes no exact row.
+    let mut overline = record.clone();
+    assert!(overline.make_move(Move::Place(p(3), Some(p(4)))));
+    assert!(overline.make_move(Move::Place(Point::new(0, 2), Some(Point::new(1, 2)))));
+    assert!(overline.make_move(Move::Place(p(5), Some(p(6)))));
+    assert_eq!(overline.test_winning_row(p(0), Direction::East), Some(p(5)));
+    assert_eq!(overline.test_exact_winning_row(p(0), Direction::East), None);
+    assert_eq!(overline.test_exact_winning_row(p(1), Direction::East), None);
+
+    // Only the options decide whether the overline wins.
+    let exact_six = GameOptions {
+        exact_six: true,
+        ..Default::default()
+    };
+    let win = Move::Win(p(0), Direction::East);
+    assert!(!overline.clone().make_move_with(exact_six, win));
+    assert!(overline.make_move_with(GameOptions::default(), win));
+
+    // An exact row is no longer one after it is extended to seven.
+    assert!(record.make_move(Move::Place(p(3), Some(p(4)))));
+    assert!(record.make_move(Move::Place(Point::new(0, 2), Some(Point::new(1, 2)))));
+    assert!(record.make_move(Move::Place(p(5), None)));
+    assert_eq!(
+        record.test_exact_winning_row(p(0), Direction::East),
+        Some(p(5))
+    );
+    assert_eq!(
+        record.test_exact_winning_row(p(5), Direction::West),
+        Some(p(0))
+    );
+    assert!(record.clone().make_move_with(exact_six, win));
+    assert!(record.make_move(Move::Place(Point::new(0, 3), Some(Point::new(1, 3)))));
+    assert!(record.make_move(Move::Place(p(-1), None)));
+    assert_eq!(record.test_exact_winning_row(p(0), Direction::East), None);
+    assert_eq!(record.test_exact_winning_row(p(-1), Direction::East), None);
+}
+
+#[test]
+fn center() {
+    let mut record = Record::new();
+    assert_eq!(record.center(), Point::ZERO);
+
+    assert!(record.make_move(Move::Place(Point::new(3, -2), None)));
+    assert_eq!(record.center(), Point::new(3, -2));
+
+    assert!(record.make_move(Move::Place(Point::new(-4, 5), Some(Point::new(8, 0)))));
+    assert_eq!(record.center(), Point::new(2, 1));
+}
+
+#[test]
+fn turn_after() {
+    let mut record = Record::new();
+    assert_eq!(record.turn_after(&[]), Some((Stone::Black, 1)));
+
+    // The opening move is a single stone, after which two are played.
+    let opening = Move::Place(Point::ZERO, None);
+    assert_eq!(record.turn_after(&[opening]), Some((Stone::White, 2)));
+    assert_eq!(record.move_index(), 0);
+
+    assert!(record.make_move(opening));
+    let pair = Move::Place(Point::new(1, 0), Some(Point::new(0, 1)));
+    assert_eq!(record.turn_after(&[pair]), Some((Stone::Black, 2)));
+    assert_eq!(record.turn_after(&[pair, pair]), None);
+    assert_eq!(record.turn_after(&[Move::Resign(Stone::White)]), None);
+}
+
+#[test]
+fn diff() {
+    let mut record = Record::new();
+    assert!(record.make_move(Move::Place(Point::ZERO, None)));
+    assert!(record.make_move(Move::Place(Point::new(1, 0), Some(Point::new(0, 1)))));
+
+    let mut other = record.clone();
+    assert_eq!(record.diff(&other), Diff::default());
+
+    // Only the current positions are compared, not the histories.
+    other.undo_move();
+    assert!(other.make_move(Move::Place(Point::new(0, 1), Some(Point::new(-1, 0)))));
+    assert!(other.make_move(Move::Place(Point::new(1, 0), None)));
+
+    let diff = record.diff(&other);
+    let mut added = vec![
+        (Point::new(1, 0), Stone::Black),
+        (Point::new(-1, 0), Stone::White),
+    ];
+    added.sort_by_key(|&(p, _)| p.index());
+    assert_eq!(diff.added, added);
+    assert_eq!(diff.removed, [(Point::new(1, 0), Stone::White)]);
+
+    assert_eq!(
+        other.diff(&record),
+        Diff {
+            added: diff.removed,
+            removed: diff.added,
+        }
+    );
+}
+
+#[test]
+fn invariants() {
+    let mut record = Record::new();
+    let check = |record: &Record| assert_eq!(record.check_invariants(), Ok(()));
+    check(&record);
+
+    assert!(record.make_move(Move::Place(Point::ZERO, None)));
+    check(&record);
+    assert!(record.make_move(Move::Place(Point::new(1, 0), Some(Point::new(0, 1)))));
+    check(&record);
+    assert!(!record.make_move(Move::Place(Point::new(2, 0), Some(Point::ZERO))));
+    check(&record);
+    assert!(record.make_move(Move::Pass));
+    assert!(record.set_comment(2, "Pass."));
+    check(&record);
+
+    record.undo_move();
+    check(&record);
+    record.redo_move();
+    check(&record);
+    assert!(record.jump(1));
+    check(&record);
+
+    // Making a move clears the future moves and their comments.
+    assert!(record.make_move(Move::Resign(Stone::White)));
+    check(&record);
+    assert_eq!(record.comment(2), None);
+
+    record.clear();
+    check(&record);
+}
+
+#[test]
+fn move_index_at() {
+    let mut record = Record::new();
+    let (p1, p2, p3) = (Point::ZERO, Point::new(1, 0), Point::new(0, 1));
+    assert_eq!(record.move_index_at(p1), None);
+
+    let moves = [Move::Place(p1, None), Move::Pass, Move::Place(p2, Some(p3))];
+    assert_eq!(record.make_moves(&moves), Ok(()));
+    assert_eq!(record.move_index_at(p1), Some(0));
+    assert_eq!(record.move_index_at(p2), Some(2));
+    assert_eq!(record.move_index_at(p3), Some(2));
+    assert_eq!(record.move_index_at(Point::new(1, 1)), None);
+
+    // Undone stones are no longer found.
+    record.undo_move();
+    assert_eq!(record.move_index_at(p2), None);
+    record.redo_move();
+    assert_eq!(record.move_index_at(p3), Some(2));
+}
+
+#[test]
+fn opening_stone_count() {
+    let mut record = Record::new();
+    let pair = Move::Place(Point::new(1, 0), Some(Point::new(0, 1)));
+
+    // The opening is a single stone.
+    assert!(!record.make_move(pair));
+    assert!(!record.has_past());
+    assert!(record.make_move(Move::Place(Point::ZERO, None)));
+
+    assert!(record.make_move(pair));
+    assert!(record.make_move(Move::Place(Point::new(2, 0), None)));
+
+    // The rule applies again after undoing back to the start.
+    assert!(record.jump(0));
+    assert!(!record.make_move(pair));
+}
+
+#[test]
+fn make_move_after_jump() {
+    let mut record = Record::new();
+    let moves = [
+        Move::Place(Point::ZERO, None),
+        Move::Place(Point::new(1, 0), Some(Point::new(0, 1))),
+        Move::Place(Point::new(2, 0), Some(Point::new(0, 2))),
+        Move::Pass,
+    ];
+    assert_eq!(record.make_moves(&moves), Ok(()));
+
+    // Making a move in the middle discards the moves after it.
+    assert!(record.jump(2));
+    let mov = Move::Place(Point::new(2, 0), Some(Point::new(3, 0)));
+    assert!(record.make_move(mov));
+    assert_eq!(record.moves(), [moves[0], moves[1], mov]);
+    assert!(!record.has_future());
+    assert_eq!(record.stone_at(Point::new(0, 2)), None);
+    assert_eq!(record.check_invariants(), Ok(()));
+}
+
+#[test]
+fn run_at() {
+    let mut record = Record::new();
+    assert_eq!(record.run_at(Point::ZERO, Direction::East), None);
+
+    let moves = [
+        Move::Place(Point::ZERO, None),
+        Move::Place(Point::new(-1, 0), Some(Point::new(0, 1))),
+        Move::Place(Point::new(1, 0), Some(Point::new(2, 0))),
+    ];
+    assert_eq!(record.make_moves(&moves), Ok(()));
+
+    let run = Run {
+        start: Point::ZERO,
+        end: Point::new(2, 0),
+        len: 3,
+        open_start: false,
+        open_end: true,
+    };
+    assert_eq!(record.run_at(Point::new(1, 0), Direction::East), Some(run));
+    assert_eq!(
+        record.run_at(Point::new(1, 0), Direction::West),
+        Some(Run {
+            start: run.end,
+            end: run.start,
+            open_start: run.open_end,
+            open_end: run.open_start,
+            ..run
+        })
+    );
+
+    let single = record.run_at(Point::ZERO, Direction::North).unwrap();
+    assert_eq!(
+        (single.start, single.end, single.len),
+        (Point::ZERO, Point::ZERO, 1)
+    );
+    assert!(!single.open_start && single.open_end);
+
+    // The edge of the placeable range blocks a run.
+    let mut record = Record::new();
+    let edge = Point::new(0x3fff, 0);
+    assert!(record.make_move(Move::Place(edge, None)));
+    let run = record.run_at(edge, Direction::East).unwrap();
+    assert!(!run.open_end && run.open_start);
+}
+
+#[test]
+fn shrink_to_fit() {
+    let mut record = Record::new();
+    assert!(record.make_move(Move::Place(Point::ZERO, None)));
+    for i in 1..100 {
+        let mov = Move::Place(Point::new(i, 0), Some(Point::new(i, 1)));
+        assert!(record.make_move(mov));
+    }
+    assert!(record.jump(10));
+    record.clear_future();
+
+    let before = record.clone();
+    record.shrink_to_fit();
+    assert_eq!(record, before);
+    assert_eq!(record.check_invariants(), Ok(()));
+}
+
+#[test]
+fn max_stones_to_play() {
+    let mut record = Record::new();
+    assert_eq!(record.max_stones_to_play(), 1);
+
+    assert!(record.make_move(Move::Place(Point::ZERO, None)));
+    assert_eq!(record.max_stones_to_play(), 2);
+    assert!(record.make_move(Move::Pass));
+    assert_eq!(record.max_stones_to_play(), 2);
+
+    assert!(record.make_move(Move::Resign(Stone::Black)));
+    assert_eq!(record.max_stones_to_play(), 0);
+
+    assert!(record.jump(0));
+    assert_eq!(record.max_stones_to_play(), 1);
+}
+
+#[test]
+fn undo_to_turn() {
+    let mut record = Record::new();
+    assert!(!record.undo_to_turn(Stone::Black));
+
+    let moves = [
+        Move::Place(Point::ZERO, None),
+        Move::Place(Point::new(1, 0), Some(Point::new(0, 1))),
+        Move::Place(Point::new(2, 0), Some(Point::new(0, 2))),
+    ];
+    assert_eq!(record.make_moves(&moves[..1]), Ok(()));
+    // White has not moved yet.
+    assert!(!record.undo_to_turn(Stone::White));
+    assert_eq!(record.move_index(), 1);
+
+    assert_eq!(record.make_moves(&moves[1..]), Ok(()));
+    // White takes back their move and Black's reply.
+    assert!(record.undo_to_turn(Stone::White));
+    assert_eq!(record.move_index(), 1);
+    assert!(record.redo_move().is_some());
+    assert!(record.undo_to_turn(Stone::Black));
+    assert_eq!(record.move_index(), 0);
+    assert!(!record.has_past());
+
+    // The undone moves can be redone.
+    assert!(record.jump(3));
+    assert_eq!(record.stone_at(Point::new(0, 2)), Some(Stone::Black));
+}
@@ -0,0 +1,52 @@
+#![allow(missing_docs)]
+
+//! Timings for very long games. Run with `cargo test --release -- --ignored`.
+
+use c6ol_core::game::{Move, Point, Record, RecordEncodingScheme};
+use std::time::Instant;
+
+const MOVES: u32 = 100_000;
+
+fn time<T>(name: &str, f: impl FnOnce() -> T) -> T {
+    let start = Instant::now();
+    let res = f();
+    eprintln!("{name}: {:?}", start.elapsed());
+    res
+}
+
+#[test]
+#[ignore = "slow; prints timings"]
+fn long_game() {
+    let mut record = Record::new();
+
+    // Fill the board outwards from the origin in index order.
+    time("make_move", || {
+        assert!(record.make_move(Move::Place(Point::ZERO, None)));
+        for i in 0..MOVES - 1 {
+            let p1 = Point::from_index(2 * i + 1);
+            let p2 = Point::from_index(2 * i + 2);
+            assert!(record.make_move(Move::Place(p1, Some(p2))));
+        }
+    });
+
+    let buf = time("encode", || {
+        record.encode_to_vec(RecordEncodingScheme::all())
+    });
+    let decoded = time("decode", || Record::decode(&mut &buf[..]).unwrap());
+    assert_eq!(decoded.moves(), record.moves());
+    eprintln!("encoded size: {} bytes", buf.len());
+
+    let rows = time("find_winning_row", || {
+        record
+            .stones()
+            .filter(|&(p, _)| record.find_winning_row(p).is_some())
+            .count()
+    });
+    assert!(rows > 0);
+
+    time("jump", || {
+        assert!(record.jump(0));
+        assert!(record.jump(MOVES as usize));
+    });
+    assert_eq!(record.check_invariants(), Ok(()));
+}
@@ -0,0 +1,308 @@
+#![allow(missing_docs)]
+
+use c6ol_core::game::{Hint, Move, Point, Record, Stone};
+use std::collections::BTreeMap;
+
+/// Creates a record where each player places one stone per turn,
+/// passing when out of stones.
+fn record_with(black: &[(i16, i16)], white: &[(i16, i16)]) -> Record {
+    let mut record = Record::new();
+    for i in 0..black.len().max(white.len()) {
+        for stones in [black, white] {
+            let mov = stones
+                .get(i)
+                .map_or(Move::Pass, |&(x, y)| Move::Place(Point::new(x, y), None));
+            assert!(record.make_move(mov));
+        }
+    }
+    record
+}
+
+fn place(p1: (i16, i16), p2: Option<(i16, i16)>) -> Move {
+    Move::Place(Point::new(p1.0, p1.1), p2.map(|(x, y)| Point::new(x, y)))
+}
+
+#[test]
+fn no_threats() {
+    let record = record_with(&[(0, 0), (1, 0), (2, 0)], &[(0, 1), (1, 1)]);
+    assert!(record.threats(Stone::Black).is_empty());
+    assert_eq!(record.blocking_moves(Stone::White), Some(vec![]));
+}
+
+#[test]
+fn block_with_one_stone() {
+    // A five with one end blocked.
+    let record = record_with(
+        &[(0, 0), (1, 0), (2, 0), (3, 0), (4, 0)],
+        &[(-1, 0), (0, 5)],
+    );
+    assert_eq!(record.threats(Stone::Black).len(), 2);
+    assert_eq!(
+        record.blocking_moves(Stone::White),
+        Some(vec![place((5, 0), None)])
+    );
+}
+
+#[test]
+fn block_with_two_stones() {
+    // An open four.
+    let record = record_with(&[(0, 0), (1, 0), (2, 0), (3, 0)], &[(0, 5), (1, 5)]);
+    assert_eq!(record.threats(Stone::Black).len(), 3);
+
+    let moves = record.blocking_moves(Stone::White).unwrap();
+    assert_eq!(moves.len(), 3);
+    for mov in [
+        place((-1, 0), Some((4, 0))),
+        place((-2, 0), Some((4, 0))),
+        place((-1, 0), Some((5, 0))),
+    ] {
+        assert!(moves.contains(&mov), "{mov:?}");
+    }
+}
+
+#[test]
+fn unblockable() {
+    // Two open fours.
+    let record = record_with(
+        &[
+            (0, 0),
+            (1, 0),
+            (2, 0),
+            (3, 0),
+            (0, 10),
+            (1, 10),
+            (2, 10),
+            (3, 10),
+        ],
+        &[(0, 5), (1, 5)],
+    );
+    assert_eq!(record.blocking_moves(Stone::White), None);
+}
+
+#[test]
+fn edge_blocks_threats() {
+    let record = record_with(
+        &[(0x3fff, 0), (0x3ffe, 0), (0x3ffd, 0), (0x3ffc, 0)],
+        &[(0, 5), (1, 5)],
+    );
+    // Only the window towards the center remains.
+    assert_eq!(
+        record.blocking_moves(Stone::White),
+        Some(vec![place((0x3ffa, 0), None), place((0x3ffb, 0), None),])
+    );
+}
+
+#[test]
+fn gapped_threats() {
+    let p = |x, y| Point::new(x, y);
+
+    // X_XXX_X: every window over four of the stones is a threat.
+    let record = record_with(&[(0, 0), (2, 0), (3, 0), (4, 0), (6, 0)], &[(0, 5), (1, 5)]);
+    let threats = record.threats(Stone::Black);
+    assert_eq!(threats.len(), 4);
+    assert!(threats.iter().any(|t| t.empty == (p(1, 0), Some(p(5, 0)))));
+    // Blocking takes two stones.
+    assert_eq!(
+        record.blocking_moves(Stone::White),
+        Some(vec![
+            place((-1, 0), Some((5, 0))),
+            place((1, 0), Some((5, 0))),
+            place((1, 0), Some((7, 0))),
+        ])
+    );
+
+    // XX_X_X: filling the two gaps wins.
+    let record = record_with(&[(0, 0), (1, 0), (3, 0), (5, 0)], &[(0, 5), (1, 5)]);
+    assert_eq!(
+        record.blocking_moves(Stone::White),
+        Some(vec![place((2, 0), None), place((4, 0), None)])
+    );
+
+    // X__X_XX: no window holds four stones.
+    let record = record_with(&[(0, 0), (3, 0), (5, 0), (6, 0)], &[(0, 5), (1, 5)]);
+    assert!(record.threats(Stone::Black).is_empty());
+}
+
+#[test]
+fn fork() {
+    let p = |x, y| Point::new(x, y);
+
+    // Two open threes.
+    let mut record = record_with(
+        &[(0, 0), (1, 0), (2, 0), (0, 10), (1, 10), (2, 10)],
+        &[(0, 5), (1, 5), (2, 5), (3, 5), (10, 5)],
+    );
+
+    // Two open fours take four stones to block.
+    assert_eq!(
+        record.creates_fork(Stone::Black, &[p(3, 0), p(3, 10)]),
+        Some(true)
+    );
+    // One open four takes two.
+    assert_eq!(
+        record.creates_fork(Stone::Black, &[p(3, 0), p(20, 20)]),
+        Some(false)
+    );
+    // Occupied or repeated positions cannot be placed at.
+    assert_eq!(record.creates_fork(Stone::Black, &[p(3, 0), p(2, 0)]), None);
+    assert_eq!(record.creates_fork(Stone::Black, &[p(3, 0), p(3, 0)]), None);
+
+    // The placements are undone.
+    assert_eq!(record.stone_at(p(3, 0)), None);
+
+    // Two closed threes.
+    let mut record = record_with(
+        &[(0, 0), (1, 0), (2, 0), (0, 10), (1, 10), (2, 10)],
+        &[(-1, 0), (-1, 10), (2, 5), (3, 5), (10, 5)],
+    );
+
+    // Two closed fours take one stone each to block.
+    assert_eq!(
+        record.creates_fork(Stone::Black, &[p(3, 0), p(3, 10)]),
+        Some(false)
+    );
+}
+
+#[test]
+fn opponent_wins_after() {
+    let p = |x, y| Point::new(x, y);
+
+    // An open four.
+    let mut record = record_with(&[(0, 0), (1, 0)], &[(0, 5), (1, 5), (2, 5), (3, 5)]);
+
+    // Blocking both ends stops it.
+    assert_eq!(
+        record.opponent_wins_after(Stone::Black, &[p(-1, 5), p(4, 5)]),
+        Some(false)
+    );
+    // Blocking one end leaves a window open at the other.
+    assert_eq!(
+        record.opponent_wins_after(Stone::Black, &[p(4, 5), p(5, 5)]),
+        Some(true)
+    );
+    assert_eq!(
+        record.opponent_wins_after(Stone::Black, &[p(2, 0), p(3, 0)]),
+        Some(true)
+    );
+
+    // The placements are undone.
+    assert_eq!(record.stone_at(p(4, 5)), None);
+
+    // Occupied positions cannot be placed at.
+    assert_eq!(
+        record.opponent_wins_after(Stone::Black, &[p(4, 5), p(3, 5)]),
+        None
+    );
+
+    let mut record = record_with(&[(0, 0), (1, 0)], &[(0, 5), (1, 5), (2, 5)]);
+    assert_eq!(
+        record.opponent_wins_after(Stone::Black, &[p(2, 0), p(3, 0)]),
+        Some(false)
+    );
+}
+
+#[test]
+fn is_quiet() {
+    assert!(Record::new().is_quiet());
+
+    // Threes pose no threat.
+    let record = record_with(&[(0, 0), (1, 0), (2, 0)], &[(0, 5), (1, 5), (2, 5)]);
+    assert!(record.is_quiet());
+
+    // A four does, for either player.
+    let record = record_with(&[(0, 0), (1, 0), (2, 0), (3, 0)], &[(0, 5)]);
+    assert!(!record.is_quiet());
+    let record = record_with(&[(0, 0)], &[(0, 5), (1, 5), (3, 5), (4, 5)]);
+    assert!(!record.is_quiet());
+
+    // Unless it is blocked on both sides.
+    let record = record_with(
+        &[(0, 0), (1, 0), (2, 0), (3, 0)],
+        &[(-1, 0), (4, 0), (0, 5)],
+    );
+    assert!(record.is_quiet());
+
+    // An unclaimed winning row is not quiet either.
+    let record = record_with(
+        &[(0, 0), (1, 0), (2, 0), (3, 0), (4, 0), (5, 0)],
+        &[(-1, 0), (6, 0)],
+    );
+    assert!(!record.is_quiet());
+}
+
+#[test]
+fn hint() {
+    assert_eq!(Record::new().hint(Stone::Black), None);
+
+    // Completing a four beats blocking the opponent's.
+    let record = record_with(
+        &[(0, 0), (1, 0), (2, 0), (3, 0)],
+        &[(0, 5), (1, 5), (2, 5), (3, 5)],
+    );
+    let (mov, hint) = record.hint(Stone::Black).unwrap();
+    assert_eq!(hint, Hint::Win);
+    let Move::Place(p1, Some(p2)) = mov else {
+        panic!("{mov:?}");
+    };
+    let mut won = record.clone();
+    assert!(won.make_move(mov));
+    assert!(won.find_winning_row(p1).is_some() || won.find_winning_row(p2).is_some());
+
+    // Without a threat of its own, White blocks.
+    let record = record_with(&[(0, 0), (1, 0), (2, 0), (3, 0)], &[(0, 5), (1, 5)]);
+    let (mov, hint) = record.hint(Stone::White).unwrap();
+    assert_eq!(hint, Hint::Block);
+    assert!(record.blocking_moves(Stone::White).unwrap().contains(&mov));
+
+    // Otherwise the longest run is extended at both ends.
+    let record = record_with(&[(0, 0), (1, 0), (5, 5)], &[(0, 5), (1, 6)]);
+    assert_eq!(
+        record.hint(Stone::Black),
+        Some((place((-1, 0), Some((2, 0))), Hint::Extend))
+    );
+    // Or twice at its open end.
+    let record = record_with(&[(0, 0), (1, 0)], &[(-1, 0), (0, 5)]);
+    assert_eq!(
+        record.hint(Stone::Black),
+        Some((place((2, 0), Some((3, 0))), Hint::Extend))
+    );
+}
+
+#[test]
+fn line_counts() {
+    let record = record_with(
+        &[
+            (0, 0),
+            (1, 0),
+            (2, 0),
+            (0, 5),
+            (0, 6),
+            (10, 0),
+            (11, 0),
+            (12, 0),
+        ],
+        &[(9, 0)],
+    );
+    // The three at `(10, 0)` is closed by the white stone.
+    assert_eq!(
+        record.line_counts(Stone::Black),
+        BTreeMap::from([(2, 1), (3, 1)])
+    );
+    assert!(record.line_counts(Stone::White).is_empty());
+}
+
+#[test]
+fn forms_threat() {
+    let p = |x, y| Point::new(x, y);
+    let mut record = record_with(&[(0, 0), (1, 0), (2, 0)], &[(0, 5), (1, 5)]);
+    assert!(!record.forms_threat(&[p(2, 0)]));
+
+    assert!(record.make_move(place((3, 0), Some((9, 9)))));
+    assert!(record.forms_threat(&[p(3, 0)]));
+    assert!(record.forms_threat(&[p(9, 9), p(3, 0)]));
+    assert!(!record.forms_threat(&[p(9, 9)]));
+
+    // The opponent's stones are checked for the opponent.
+    assert!(!record.forms_threat(&[p(0, 5), p(1, 5)]));
+    assert!(!record.forms_threat(&[p(4, 0)]));
+}
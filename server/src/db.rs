@@ -1,8 +1,11 @@
-use crate::{game::GameState, macros::exec};
+use crate::{
+    game::{GameState, Retraction},
+    macros::exec,
+};
 use anyhow::Context;
 use c6ol_core::{
-    game::{Record, RecordEncodingScheme},
-    protocol::{GameId, GameOptions, Message, Player, Request},
+    game::{Move, Record, RecordEncodingScheme},
+    protocol::{GameId, GameOptions, Message, PasscodeHash, Player, Request, WatchToken},
 };
 use chrono::Utc;
 use rusqlite::{Connection, Row};
@@ -18,8 +21,17 @@ enum Command {
     Create(oneshot::Sender<(GameId, Box<GameState>)>, GameOptions),
     Load(oneshot::Sender<Option<Box<GameState>>>, GameId),
     Save(oneshot::Sender<Option<bool>>, GameId, Box<GameState>),
+    Retractions(
+        oneshot::Sender<Option<Vec<Retraction>>>,
+        GameId,
+        PasscodeHash,
+    ),
+    WatchToken(oneshot::Sender<WatchToken>, GameId),
+    RevokeWatchToken(oneshot::Sender<()>, GameId),
+    ResolveWatchToken(oneshot::Sender<Option<GameId>>, WatchToken),
 }
 
+#[derive(Clone)]
 pub struct DbManager {
     cmd_tx: mpsc::Sender<Command>,
 }
@@ -36,6 +48,27 @@ impl DbManager {
     pub async fn save(&self, id: GameId, state: Box<GameState>) -> Option<bool> {
         exec!(self.cmd_tx, Command::Save, id, state)
     }
+
+    /// Returns the saved retractions of a game, oldest first,
+    /// or `None` if no seat of the game has the given passcode hash.
+    pub async fn retractions(&self, id: GameId, hash: PasscodeHash) -> Option<Vec<Retraction>> {
+        exec!(self.cmd_tx, Command::Retractions, id, hash)
+    }
+
+    /// Returns the watch token of a game, creating a random one if none.
+    pub async fn watch_token(&self, id: GameId) -> WatchToken {
+        exec!(self.cmd_tx, Command::WatchToken, id)
+    }
+
+    /// Deletes the watch token of a game, if any.
+    pub async fn revoke_watch_token(&self, id: GameId) {
+        exec!(self.cmd_tx, Command::RevokeWatchToken, id);
+    }
+
+    /// Returns the ID of the game a watch token belongs to.
+    pub async fn resolve_watch_token(&self, token: WatchToken) -> Option<GameId> {
+        exec!(self.cmd_tx, Command::ResolveWatchToken, token)
+    }
 }
 
 pub fn manager(path: Option<PathBuf>) -> (DbManager, task::JoinHandle<()>) {
@@ -51,7 +84,7 @@ pub fn manager(path: Option<PathBuf>) -> (DbManager, task::JoinHandle<()>) {
 }
 
 fn manage_db(path: Option<PathBuf>, mut cmd_rx: mpsc::Receiver<Command>) -> anyhow::Result<()> {
-    let conn = match path {
+    let mut conn = match path {
         Some(path) => Connection::open(path)?,
         None => Connection::open_in_memory()?,
     };
@@ -74,6 +107,25 @@ fn manage_db(path: Option<PathBuf>, mut cmd_rx: mpsc::Receiver<Command>) -> anyh
         (),
     )?;
 
+    conn.execute(
+        "CREATE TABLE IF NOT EXISTS retraction (
+            game_id INTEGER NOT NULL,
+            move_index INTEGER NOT NULL,
+            move BLOB NOT NULL,
+            requester INTEGER NOT NULL,
+            created_at INTEGER NOT NULL
+        ) STRICT",
+        (),
+    )?;
+
+    conn.execute(
+        "CREATE TABLE IF NOT EXISTS watch_token (
+            token INTEGER NOT NULL PRIMARY KEY,
+            game_id INTEGER NOT NULL UNIQUE
+        ) STRICT",
+        (),
+    )?;
+
     while let Some(cmd) = cmd_rx.blocking_recv() {
         match cmd {
             Command::Create(resp_tx, options) => {
@@ -114,10 +166,14 @@ fn manage_db(path: Option<PathBuf>, mut cmd_rx: mpsc::Receiver<Command>) -> anyh
                 _ = resp_tx.send(resp);
             }
             Command::Save(resp_tx, id, state) => {
+                // Save the game and its retractions as a whole.
+                let tx = conn.transaction()?;
                 if !state.should_remain() {
-                    conn.execute("DELETE FROM game WHERE id = ?1", [id.0])?;
+                    tx.execute("DELETE FROM game WHERE id = ?1", [id.0])?;
+                    tx.execute("DELETE FROM retraction WHERE game_id = ?1", [id.0])?;
+                    tx.execute("DELETE FROM watch_token WHERE game_id = ?1", [id.0])?;
                 } else if state.changed {
-                    conn.execute(
+                    tx.execute(
                         "UPDATE game SET options = ?1,
                         passcode_host = ?2, passcode_guest = ?3,
                         request_host = ?4, request_guest = ?5,
@@ -133,9 +189,70 @@ fn manage_db(path: Option<PathBuf>, mut cmd_rx: mpsc::Receiver<Command>) -> anyh
                             id.0,
                         ),
                     )?;
+
+                    let mut stmt = tx.prepare(
+                        "INSERT INTO retraction
+                            (game_id, move_index, move, requester, created_at)
+                            VALUES (?1, ?2, ?3, ?4, ?5)",
+                    )?;
+                    for r in &state.retractions {
+                        let mut mov = vec![];
+                        r.mov.encode(&mut mov, false);
+                        stmt.execute((id.0, r.index as i64, mov, r.requester as u8, r.timestamp))?;
+                    }
                 }
+                tx.commit()?;
                 _ = resp_tx.send(state.should_remain().then_some(state.changed));
             }
+            Command::Retractions(resp_tx, id, hash) => {
+                let mut stmt = conn.prepare(
+                    "SELECT 1 FROM game WHERE id = ?1 AND ?2 IN (passcode_host, passcode_guest)",
+                )?;
+                if !stmt.exists((id.0, hash))? {
+                    _ = resp_tx.send(None);
+                    continue;
+                }
+
+                let mut stmt = conn.prepare(
+                    "SELECT move_index, move, requester, created_at FROM retraction
+                        WHERE game_id = ?1 ORDER BY rowid",
+                )?;
+                let resp = stmt
+                    .query_and_then([id.0], parse_retraction)?
+                    .collect::<anyhow::Result<_>>()?;
+                _ = resp_tx.send(Some(resp));
+            }
+            Command::WatchToken(resp_tx, id) => {
+                let mut stmt = conn.prepare("SELECT token FROM watch_token WHERE game_id = ?1")?;
+                if let Some(row) = stmt.query([id.0])?.next()? {
+                    _ = resp_tx.send(WatchToken(row.get(0)?));
+                    continue;
+                }
+
+                let mut stmt = conn.prepare(
+                    "INSERT OR IGNORE INTO watch_token (token, game_id) VALUES (?1, ?2)",
+                )?;
+                let token = loop {
+                    let token = WatchToken(rand::random());
+                    let rows = stmt.execute((token.0, id.0))?;
+                    if rows > 0 {
+                        break token;
+                    }
+                };
+                _ = resp_tx.send(token);
+            }
+            Command::RevokeWatchToken(resp_tx, id) => {
+                conn.execute("DELETE FROM watch_token WHERE game_id = ?1", [id.0])?;
+                _ = resp_tx.send(());
+            }
+            Command::ResolveWatchToken(resp_tx, token) => {
+                let mut stmt = conn.prepare("SELECT game_id FROM watch_token WHERE token = ?1")?;
+                let resp = match stmt.query([token.0])?.next()? {
+                    Some(row) => Some(GameId(row.get(0)?)),
+                    None => None,
+                };
+                _ = resp_tx.send(resp);
+            }
         }
     }
 
@@ -165,3 +282,13 @@ fn parse_state(row: &Row<'_>) -> anyhow::Result<Box<GameState>> {
 
     Ok(state)
 }
+
+fn parse_retraction(row: &Row<'_>) -> anyhow::Result<Retraction> {
+    Ok(Retraction {
+        index: row.get::<_, i64>("move_index")?.try_into()?,
+        mov: Move::decode(&mut row.get_ref("move")?.as_blob()?, false)
+            .context("failed to decode move")?,
+        requester: Player::from_u8(row.get("requester")?).context("invalid requester")?,
+        timestamp: row.get("created_at")?,
+    })
+}
@@ -4,17 +4,24 @@ use crate::{db::DbManager, macros::exec};
 use c6ol_core::{
     game::{Move, Record},
     protocol::{
-        ClientMessage, GameId, GameOptions, PasscodeHash, Player, PlayerSlots, Request,
-        ServerMessage,
+        ClientMessage, GameId, GameOptions, PasscodeHash, Player, PlayerSlots, Rejection, Request,
+        ServerMessage, WatchToken,
     },
 };
-use std::collections::HashMap;
-use tokio::sync::{broadcast, mpsc, oneshot};
+use chrono::Utc;
+use std::{collections::HashMap, iter, time::Duration};
+use tokio::{
+    sync::{broadcast, mpsc, oneshot, watch},
+    time::Instant,
+};
 use tokio_util::task::JoinMap;
 
 const CHANNEL_CAPACITY_MANAGE_CMD: usize = 64;
 const CHANNEL_CAPACITY_GAME_CMD: usize = 8;
 const CHANNEL_CAPACITY_GAME_MSG: usize = 8;
+const CHANNEL_CAPACITY_GAME_CHAT: usize = 16;
+
+const CHAT_MIN_INTERVAL: Duration = Duration::from_secs(1);
 
 /// A subscription to a game.
 pub struct GameSubscription {
@@ -22,19 +29,44 @@ pub struct GameSubscription {
     pub init_msgs: Box<[ServerMessage]>,
     /// The receiver for future messages.
     pub msg_rx: broadcast::Receiver<ServerMessage>,
+    /// The receiver for chat messages, which may be dropped on lag.
+    pub chat_rx: broadcast::Receiver<ServerMessage>,
+    /// The receiver notified when the watch token is revoked.
+    pub watch_revoked_rx: watch::Receiver<()>,
 }
 
 enum GameCommand {
-    Subscribe(oneshot::Sender<GameSubscription>),
-    Authenticate(oneshot::Sender<Option<Player>>, PasscodeHash),
-    Play(Player, ClientMessage),
+    Subscribe(
+        oneshot::Sender<Option<GameSubscription>>,
+        Option<WatchToken>,
+    ),
+    Authenticate(
+        oneshot::Sender<Option<Player>>,
+        PasscodeHash,
+        Option<Player>,
+    ),
+    Play(
+        oneshot::Sender<Result<(), Rejection>>,
+        Player,
+        u32,
+        ClientMessage,
+    ),
+    Chat(Option<Player>, Box<str>),
+    WatchToken(oneshot::Sender<WatchToken>),
+    RevokeWatchToken(oneshot::Sender<()>),
 }
 
 /// A command handle to a game.
 pub struct Game {
     id: GameId,
     cmd_tx: mpsc::Sender<GameCommand>,
+    /// The player assigned when matched, to authenticate as.
+    seat: Option<Player>,
+    /// The token given to watch the game with.
+    watch_token: Option<WatchToken>,
     player: Option<Player>,
+    /// The latest epoch acknowledged by the client.
+    epoch: u32,
 }
 
 impl Game {
@@ -42,7 +74,10 @@ impl Game {
         Self {
             id,
             cmd_tx,
+            seat: None,
+            watch_token: None,
             player: None,
+            epoch: 0,
         }
     }
 
@@ -52,8 +87,10 @@ impl Game {
     }
 
     /// Subscribes to the game.
-    pub async fn subscribe(&self) -> GameSubscription {
-        exec!(self.cmd_tx, GameCommand::Subscribe,)
+    ///
+    /// Returns `None` if the handle is for watching and the token was revoked.
+    pub async fn subscribe(&self) -> Option<GameSubscription> {
+        exec!(self.cmd_tx, GameCommand::Subscribe, self.watch_token)
     }
 
     /// Attempts to authenticate with the given passcode hash.
@@ -65,7 +102,7 @@ impl Game {
     /// Panics if the handle is already authenticated.
     pub async fn authenticate(&mut self, hash: PasscodeHash) -> Option<Player> {
         assert!(self.player.is_none(), "already authenticated");
-        self.player = exec!(self.cmd_tx, GameCommand::Authenticate, hash);
+        self.player = exec!(self.cmd_tx, GameCommand::Authenticate, hash, self.seat);
         self.player
     }
 
@@ -76,18 +113,50 @@ impl Game {
 
     /// Attempts to play the game by making the action described in the message.
     ///
+    /// # Errors
+    ///
+    /// Returns the reason if the message was rejected.
+    ///
     /// # Panics
     ///
     /// Panics if the handle is unauthenticated.
-    pub async fn play(&self, msg: ClientMessage) {
+    pub async fn play(&self, msg: ClientMessage) -> Result<(), Rejection> {
         let player = self.player.expect("unauthenticated");
-        exec!(self.cmd_tx, GameCommand::Play(player, msg));
+        exec!(self.cmd_tx, GameCommand::Play, player, self.epoch, msg)
+    }
+
+    /// Records that the client has seen the game in the given epoch,
+    /// so that later messages are played in it.
+    pub fn ack_epoch(&mut self, epoch: u32) {
+        self.epoch = epoch;
+    }
+
+    /// Sends a chat message to all subscribers, tagged with the assigned player.
+    ///
+    /// The message is silently dropped if sent too soon after the last one
+    /// from the same player, or from any spectator.
+    pub async fn chat(&self, text: Box<str>) {
+        let sender = self.player;
+        exec!(self.cmd_tx, GameCommand::Chat(sender, text));
+    }
+
+    /// Returns the watch token of the game, creating one if none.
+    pub async fn watch_token(&self) -> WatchToken {
+        exec!(self.cmd_tx, GameCommand::WatchToken,)
+    }
+
+    /// Revokes the watch token of the game, notifying all subscribers.
+    pub async fn revoke_watch_token(&self) {
+        exec!(self.cmd_tx, GameCommand::RevokeWatchToken,);
     }
 }
 
 enum GameManageCommand {
     Create(oneshot::Sender<Game>, GameOptions),
     Find(oneshot::Sender<Option<Game>>, GameId),
+    Watch(oneshot::Sender<Option<Game>>, WatchToken),
+    Match(oneshot::Sender<Game>),
+    QueueLen(oneshot::Sender<usize>),
 }
 
 /// Creates a game manager.
@@ -114,6 +183,26 @@ impl GameManager {
     pub async fn find(&self, id: GameId) -> Option<Game> {
         exec!(self.cmd_tx, GameManageCommand::Find, id)
     }
+
+    /// Searches for the game a watch token was given for.
+    ///
+    /// The returned handle cannot take a seat.
+    pub async fn watch(&self, token: WatchToken) -> Option<Game> {
+        exec!(self.cmd_tx, GameManageCommand::Watch, token)
+    }
+
+    /// Waits for another player to match, then starts a new game
+    /// with the default options for both players.
+    ///
+    /// Dropping the future leaves the queue.
+    pub async fn match_player(&self) -> Game {
+        exec!(self.cmd_tx, GameManageCommand::Match,)
+    }
+
+    /// Returns the number of players waiting to be matched.
+    pub async fn queue_len(&self) -> usize {
+        exec!(self.cmd_tx, GameManageCommand::QueueLen,)
+    }
 }
 
 async fn manage_games(db_manager: DbManager, mut cmd_rx: mpsc::Receiver<GameManageCommand>) {
@@ -122,6 +211,9 @@ async fn manage_games(db_manager: DbManager, mut cmd_rx: mpsc::Receiver<GameMana
     let mut game_cmd_txs = HashMap::new();
     let mut game_tasks = JoinMap::new();
 
+    // The player waiting to be matched.
+    let mut waiting_tx = None::<oneshot::Sender<Game>>;
+
     loop {
         tokio::select! {
             opt = cmd_rx.recv() => {
@@ -136,37 +228,55 @@ async fn manage_games(db_manager: DbManager, mut cmd_rx: mpsc::Receiver<GameMana
                         let (game_cmd_tx, game_cmd_rx) = mpsc::channel(CHANNEL_CAPACITY_GAME_CMD);
                         game_cmd_txs.insert(id, game_cmd_tx.downgrade());
 
-                        game_tasks.spawn(id, manage_game(state, game_cmd_rx));
+                        game_tasks.spawn(id, manage_game(id, state, game_cmd_rx, db_manager.clone()));
 
                         _ = resp_tx.send(Game::new(id, game_cmd_tx));
 
                         tracing::info!("game started: {id}");
                     },
                     GameManageCommand::Find(resp_tx, id) => {
-                        if let Some(tx) = game_cmd_txs.get(&id) {
-                            if let Some(tx) = tx.upgrade() {
-                                // There is a chance that all senders have been dropped
-                                // but the game task has not finished yet.
-                                _ = resp_tx.send(Some(Game::new(id, tx)));
-                            } else {
-                                // The game is saving.
-                                _ = resp_tx.send(None);
-                            }
+                        let game = find_game(id, &db_manager, &mut game_cmd_txs, &mut game_tasks).await;
+                        _ = resp_tx.send(game);
+                    }
+                    GameManageCommand::Watch(resp_tx, token) => {
+                        let game = match db_manager.resolve_watch_token(token).await {
+                            Some(id) => find_game(id, &db_manager, &mut game_cmd_txs, &mut game_tasks).await,
+                            None => None,
+                        };
+                        _ = resp_tx.send(game.map(|game| Game {
+                            watch_token: Some(token),
+                            ..game
+                        }));
+                    }
+                    GameManageCommand::Match(resp_tx) => {
+                        // Skip the waiting player if they have left.
+                        let Some(other_tx) = waiting_tx.take().filter(|tx| !tx.is_closed()) else {
+                            waiting_tx = Some(resp_tx);
                             continue;
-                        }
+                        };
 
-                        if let Some(state) = db_manager.load(id).await {
-                            let (game_cmd_tx, game_cmd_rx) = mpsc::channel(CHANNEL_CAPACITY_GAME_CMD);
-                            game_cmd_txs.insert(id, game_cmd_tx.downgrade());
+                        let (id, state) = db_manager.create(GameOptions::default()).await;
 
-                            game_tasks.spawn(id, manage_game(state, game_cmd_rx));
+                        let (game_cmd_tx, game_cmd_rx) = mpsc::channel(CHANNEL_CAPACITY_GAME_CMD);
+                        game_cmd_txs.insert(id, game_cmd_tx.downgrade());
 
-                            _ = resp_tx.send(Some(Game::new(id, game_cmd_tx)));
+                        game_tasks.spawn(id, manage_game(id, state, game_cmd_rx, db_manager.clone()));
 
-                            tracing::info!("game loaded: {id}");
-                        } else {
-                            _ = resp_tx.send(None);
+                        // Assign the seats now, so that the players cannot
+                        // both end up as the host by racing to authenticate.
+                        for (tx, seat) in [(other_tx, Player::Host), (resp_tx, Player::Guest)] {
+                            let game = Game {
+                                seat: Some(seat),
+                                ..Game::new(id, game_cmd_tx.clone())
+                            };
+                            _ = tx.send(game);
                         }
+
+                        tracing::info!("game matched: {id}");
+                    }
+                    GameManageCommand::QueueLen(resp_tx) => {
+                        let len = waiting_tx.as_ref().filter(|tx| !tx.is_closed()).map_or(0, |_| 1);
+                        _ = resp_tx.send(len);
                     }
                 }
             }
@@ -196,12 +306,55 @@ async fn manage_games(db_manager: DbManager, mut cmd_rx: mpsc::Receiver<GameMana
     tracing::info!("game manager stopped");
 }
 
+/// Returns a handle to the game with the given ID, loading it if needed.
+async fn find_game(
+    id: GameId,
+    db_manager: &DbManager,
+    game_cmd_txs: &mut HashMap<GameId, mpsc::WeakSender<GameCommand>>,
+    game_tasks: &mut JoinMap<GameId, Box<GameState>>,
+) -> Option<Game> {
+    if let Some(tx) = game_cmd_txs.get(&id) {
+        // There is a chance that all senders have been dropped
+        // but the game task has not finished yet, in which case
+        // the game is saving.
+        return tx.upgrade().map(|tx| Game::new(id, tx));
+    }
+
+    let state = db_manager.load(id).await?;
+
+    let (game_cmd_tx, game_cmd_rx) = mpsc::channel(CHANNEL_CAPACITY_GAME_CMD);
+    game_cmd_txs.insert(id, game_cmd_tx.downgrade());
+
+    game_tasks.spawn(id, manage_game(id, state, game_cmd_rx, db_manager.clone()));
+
+    tracing::info!("game loaded: {id}");
+    Some(Game::new(id, game_cmd_tx))
+}
+
+/// A retracted move, kept for auditing.
+pub struct Retraction {
+    /// The index of the retracted move.
+    pub index: usize,
+    /// The retracted move.
+    pub mov: Move,
+    /// The player who requested the retraction.
+    pub requester: Player,
+    /// When the retraction was accepted, in milliseconds since the epoch.
+    pub timestamp: i64,
+}
+
 #[derive(Default)]
 pub struct GameState {
     pub options: GameOptions,
     pub passcode_hashes: PlayerSlots<Option<PasscodeHash>>,
     pub requests: PlayerSlots<Option<Request>>,
     pub record: Record,
+    /// Retractions since the game was loaded, yet to be saved.
+    pub retractions: Vec<Retraction>,
+    /// Moves to make once it is each player's turn, which are not saved.
+    pub premoves: PlayerSlots<Option<Move>>,
+    /// The number of resets since the game was loaded, which is not saved.
+    pub epoch: u32,
     pub changed: bool,
 }
 
@@ -210,50 +363,77 @@ impl GameState {
         self.passcode_hashes[Player::Host].is_some()
     }
 
-    fn subscribe(&self, msg_tx: &broadcast::Sender<ServerMessage>) -> GameSubscription {
+    fn seats_filled(&self) -> bool {
+        [Player::Host, Player::Guest]
+            .into_iter()
+            .all(|player| self.passcode_hashes[player].is_some())
+    }
+
+    fn subscribe(
+        &self,
+        msg_tx: &broadcast::Sender<ServerMessage>,
+        chat_tx: &broadcast::Sender<ServerMessage>,
+        watch_revoked_tx: &watch::Sender<()>,
+    ) -> GameSubscription {
         GameSubscription {
             init_msgs: [
+                ServerMessage::Epoch(self.epoch),
                 ServerMessage::Options(self.options),
                 ServerMessage::Record(Box::new(self.record.clone())),
             ]
             .into_iter()
+            .chain(self.seats_filled().then_some(ServerMessage::SeatsFilled))
             .chain([Player::Host, Player::Guest].iter().filter_map(|&player| {
                 self.requests[player].map(|req| ServerMessage::Request(player, req))
             }))
             .collect(),
             msg_rx: msg_tx.subscribe(),
+            chat_rx: chat_tx.subscribe(),
+            watch_revoked_rx: watch_revoked_tx.subscribe(),
         }
     }
 
-    fn authenticate(&mut self, hash: PasscodeHash) -> Option<Player> {
-        if let Some(hash_host) = self.passcode_hashes[Player::Host] {
-            if hash == hash_host {
-                Some(Player::Host)
-            } else if let Some(hash_guest) = self.passcode_hashes[Player::Guest] {
-                if hash == hash_guest {
-                    Some(Player::Guest)
-                } else {
-                    // Wrong passcode.
-                    None
-                }
-            } else {
-                self.passcode_hashes[Player::Guest] = Some(hash);
-                self.changed = true;
-                Some(Player::Guest)
+    fn authenticate(
+        &mut self,
+        hash: PasscodeHash,
+        seat: Option<Player>,
+        msg_tx: &broadcast::Sender<ServerMessage>,
+    ) -> Option<Player> {
+        // A returning player, unless a matched player reuses the opponent's passcode.
+        for player in [Player::Host, Player::Guest] {
+            if self.passcode_hashes[player] == Some(hash) {
+                return seat.is_none_or(|seat| seat == player).then_some(player);
             }
-        } else {
-            self.passcode_hashes[Player::Host] = Some(hash);
-            self.changed = true;
-            Some(Player::Host)
         }
+
+        // A matched player takes the assigned seat, and others the first empty one.
+        let player = match seat {
+            Some(seat) => seat,
+            None if self.passcode_hashes[Player::Host].is_none() => Player::Host,
+            None => Player::Guest,
+        };
+        if self.passcode_hashes[player].is_some() {
+            // Wrong passcode.
+            return None;
+        }
+
+        self.passcode_hashes[player] = Some(hash);
+        // Matched players may take their seats in either order.
+        if self.seats_filled() {
+            _ = msg_tx.send(ServerMessage::SeatsFilled);
+        }
+
+        self.changed = true;
+        Some(player)
     }
 
     fn play(
         &mut self,
         player: Player,
+        epoch: u32,
         msg: ClientMessage,
         msg_tx: &broadcast::Sender<ServerMessage>,
-    ) {
+    ) -> Result<(), Rejection> {
         use ClientMessage as Msg;
 
         enum Action {
@@ -262,21 +442,55 @@ impl GameState {
             Reset(GameOptions),
         }
 
+        if !self.seats_filled() {
+            return Err(Rejection::SeatsOpen);
+        }
+
+        // The message was meant for the game before a reset.
+        if epoch != self.epoch {
+            return Err(Rejection::StaleEpoch);
+        }
+
         let stone = self.options.stone_of(player);
 
         let action = match msg {
-            Msg::Start(..) | Msg::Join(_) | Msg::Authenticate(_) => return,
-            Msg::Place(p1, p2) => {
+            Msg::Start(..)
+            | Msg::Join(_)
+            | Msg::Watch(_)
+            | Msg::Match
+            | Msg::Authenticate(_)
+            | Msg::Chat(_)
+            | Msg::GetWatchToken
+            | Msg::RevokeWatchToken
+            | Msg::AckEpoch(_) => {
+                return Err(Rejection::UnexpectedMessage);
+            }
+            Msg::Premove(p1, p2) if self.record.turn() != Some(stone) => {
+                if self.record.turn() != Some(stone.opposite()) {
+                    // Not the opponent's turn, so there is nothing to wait for.
+                    return Err(Rejection::NotTheirTurn);
+                }
+                self.premoves[player] = Some(Move::Place(p1, p2));
+                return Ok(());
+            }
+            // A premove that arrives on the player's turn is placed right away.
+            Msg::Place(p1, p2) | Msg::Premove(p1, p2) => {
+                // Messages are processed one at a time, so a move that raced
+                // with the opponent's is checked against the state after it.
                 if self.record.turn() != Some(stone) {
-                    // Not their turn.
-                    return;
+                    return Err(Rejection::NotTheirTurn);
+                }
+                if iter::once(p1)
+                    .chain(p2)
+                    .any(|p| self.record.stone_at(p).is_some())
+                {
+                    return Err(Rejection::Occupied);
                 }
                 Action::Move(Move::Place(p1, p2))
             }
             Msg::Pass => {
                 if self.record.turn() != Some(stone) {
-                    // Not their turn.
-                    return;
+                    return Err(Rejection::NotTheirTurn);
                 }
                 Action::Move(Move::Pass)
             }
@@ -285,25 +499,22 @@ impl GameState {
             Msg::Request(req) => {
                 let player_req = &mut self.requests[player];
                 if player_req.is_some() {
-                    // Duplicate request.
-                    return;
+                    return Err(Rejection::DuplicateRequest);
                 }
 
                 if req == Request::Retract && !self.record.has_past() {
-                    // No moves in the past.
-                    return;
+                    return Err(Rejection::NoPastMove);
                 }
 
                 *player_req = Some(req);
                 _ = msg_tx.send(ServerMessage::Request(player, req));
 
                 self.changed = true;
-                return;
+                return Ok(());
             }
             Msg::AcceptRequest => {
                 let Some(req) = self.requests[player.opposite()] else {
-                    // The opponent hasn't made a request.
-                    return;
+                    return Err(Rejection::NoRequest);
                 };
 
                 match req {
@@ -313,33 +524,46 @@ impl GameState {
                 }
             }
             Msg::DeclineRequest => {
-                if self.requests[player.opposite()].take().is_some() {
-                    // Inform the opponent of the decline.
-                    _ = msg_tx.send(ServerMessage::DeclineRequest(player));
-
-                    self.changed = true;
+                if self.requests[player.opposite()].take().is_none() {
+                    return Err(Rejection::NoRequest);
                 }
-                return;
+
+                // Inform the opponent of the decline.
+                _ = msg_tx.send(ServerMessage::DeclineRequest(player));
+
+                self.changed = true;
+                return Ok(());
             }
         };
 
         match action {
             Action::Move(mov) => {
-                if !self.record.make_move(mov) {
-                    // The move failed.
-                    return;
+                if !self.record.make_move_with(self.options, mov) {
+                    return Err(Rejection::IllegalMove);
                 }
                 _ = msg_tx.send(ServerMessage::Move(mov));
+
+                self.make_premove(msg_tx);
             }
             Action::Retract => {
                 // We have checked that there is a previous move.
-                self.record.undo_move();
+                let mov = self.record.undo_move().unwrap();
+                self.retractions.push(Retraction {
+                    index: self.record.move_index(),
+                    mov,
+                    requester: player.opposite(),
+                    timestamp: Utc::now().timestamp_millis(),
+                });
+                self.premoves.fill(None);
                 _ = msg_tx.send(ServerMessage::Retract);
             }
             Action::Reset(options) => {
                 self.options = options;
                 self.record = Default::default();
+                self.premoves.fill(None);
+                self.epoch = self.epoch.wrapping_add(1);
 
+                _ = msg_tx.send(ServerMessage::Epoch(self.epoch));
                 _ = msg_tx.send(ServerMessage::Options(options));
                 _ = msg_tx.send(ServerMessage::Record(Default::default()));
             }
@@ -355,27 +579,253 @@ impl GameState {
         }
 
         self.changed = true;
+        Ok(())
+    }
+
+    /// Makes the premove of the player to play (if any),
+    /// informing the players if it fails.
+    fn make_premove(&mut self, msg_tx: &broadcast::Sender<ServerMessage>) {
+        let Some(stone) = self.record.turn() else {
+            // The game is ended.
+            self.premoves.fill(None);
+            return;
+        };
+        let player = if self.options.stone_of(Player::Host) == stone {
+            Player::Host
+        } else {
+            Player::Guest
+        };
+        let Some(mov) = self.premoves[player].take() else {
+            return;
+        };
+
+        if self.record.make_move_with(self.options, mov) {
+            _ = msg_tx.send(ServerMessage::Move(mov));
+        } else {
+            _ = msg_tx.send(ServerMessage::PremoveFailed(player));
+        }
     }
 }
 
 async fn manage_game(
+    id: GameId,
     mut state: Box<GameState>,
     mut cmd_rx: mpsc::Receiver<GameCommand>,
+    db_manager: DbManager,
 ) -> Box<GameState> {
     let (msg_tx, _) = broadcast::channel(CHANNEL_CAPACITY_GAME_MSG);
+    let (chat_tx, _) = broadcast::channel(CHANNEL_CAPACITY_GAME_CHAT);
+    let (watch_revoked_tx, _) = watch::channel(());
+
+    // When the host, the guest and any spectator last chatted, which limits
+    // the rate however many connections each of them has.
+    let mut last_chats = [None::<Instant>; 3];
 
     while let Some(cmd) = cmd_rx.recv().await {
         match cmd {
-            GameCommand::Subscribe(resp_tx) => {
-                _ = resp_tx.send(state.subscribe(&msg_tx));
+            GameCommand::Subscribe(resp_tx, token) => {
+                // Check the token here, in turn with revocations,
+                // so that no one can start watching with a revoked token.
+                if let Some(token) = token
+                    && db_manager.resolve_watch_token(token).await != Some(id)
+                {
+                    _ = resp_tx.send(None);
+                    continue;
+                }
+                _ = resp_tx.send(Some(state.subscribe(&msg_tx, &chat_tx, &watch_revoked_tx)));
+            }
+            GameCommand::Authenticate(resp_tx, hash, seat) => {
+                _ = resp_tx.send(state.authenticate(hash, seat, &msg_tx));
+            }
+            GameCommand::Play(resp_tx, player, epoch, msg) => {
+                let res = state.play(player, epoch, msg, &msg_tx);
+                if let Err(reason) = res {
+                    tracing::debug!(game = %id, ?player, ?reason, "message rejected");
+                }
+                _ = resp_tx.send(res);
+            }
+            GameCommand::Chat(sender, text) => {
+                let last_chat = &mut last_chats[sender.map_or(2, |player| player as usize)];
+                let now = Instant::now();
+                if last_chat.is_none_or(|t| now - t >= CHAT_MIN_INTERVAL) {
+                    *last_chat = Some(now);
+                    _ = chat_tx.send(ServerMessage::Chat(sender, text));
+                }
+            }
+            GameCommand::WatchToken(resp_tx) => {
+                _ = resp_tx.send(db_manager.watch_token(id).await);
             }
-            GameCommand::Authenticate(resp_tx, hash) => {
-                _ = resp_tx.send(state.authenticate(hash));
+            GameCommand::RevokeWatchToken(resp_tx) => {
+                db_manager.revoke_watch_token(id).await;
+                watch_revoked_tx.send_replace(());
+                _ = resp_tx.send(());
             }
-            GameCommand::Play(player, msg) => state.play(player, msg, &msg_tx),
         }
     }
 
     // All command senders are dropped.
     state
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use c6ol_core::{game::Point, protocol::Message};
+
+    fn joined_state() -> (Box<GameState>, broadcast::Sender<ServerMessage>) {
+        let (msg_tx, _) = broadcast::channel(CHANNEL_CAPACITY_GAME_MSG);
+        let mut state = Box::new(GameState::default());
+        assert_eq!(state.authenticate(1, None, &msg_tx), Some(Player::Host));
+        assert_eq!(state.authenticate(2, None, &msg_tx), Some(Player::Guest));
+        (state, msg_tx)
+    }
+
+    #[test]
+    fn racing_places() {
+        let (mut state, msg_tx) = joined_state();
+        let mut msg_rx = msg_tx.subscribe();
+
+        let (p1, p2) = (Point::new(0, 0), Point::new(1, 0));
+        assert_eq!(
+            state.play(Player::Host, 0, ClientMessage::Place(p1, None), &msg_tx),
+            Ok(())
+        );
+        // Sent by the guest before the host's move arrived.
+        assert_eq!(
+            state.play(
+                Player::Guest,
+                0,
+                ClientMessage::Place(p1, Some(p2)),
+                &msg_tx
+            ),
+            Err(Rejection::Occupied)
+        );
+        // Sent twice by the host.
+        assert_eq!(
+            state.play(Player::Host, 0, ClientMessage::Place(p2, None), &msg_tx),
+            Err(Rejection::NotTheirTurn)
+        );
+
+        // Only the first move is made and broadcast.
+        assert_eq!(state.record.move_index(), 1);
+        assert!(matches!(msg_rx.try_recv(), Ok(ServerMessage::Move(_))));
+        assert!(msg_rx.try_recv().is_err());
+    }
+
+    #[test]
+    fn late_premove() {
+        let (mut state, msg_tx) = joined_state();
+
+        let place = ClientMessage::Place(Point::ZERO, None);
+        assert_eq!(state.play(Player::Host, 0, place, &msg_tx), Ok(()));
+
+        // Sent by the guest before the host's move arrived.
+        let (p1, p2) = (Point::new(1, 0), Point::new(2, 0));
+        assert_eq!(
+            state.play(
+                Player::Guest,
+                0,
+                ClientMessage::Premove(p1, Some(p2)),
+                &msg_tx
+            ),
+            Ok(())
+        );
+
+        // It is made right away instead of waiting for the next turn.
+        assert_eq!(state.record.move_index(), 2);
+        assert!(state.premoves[Player::Guest].is_none());
+    }
+
+    #[test]
+    fn stale_messages_after_reset() {
+        let (mut state, msg_tx) = joined_state();
+
+        let place = ClientMessage::Place(Point::ZERO, None);
+        assert_eq!(state.play(Player::Host, 0, place.clone(), &msg_tx), Ok(()));
+        let reset = ClientMessage::Request(Request::Reset(GameOptions::default()));
+        assert_eq!(state.play(Player::Host, 0, reset, &msg_tx), Ok(()));
+        assert_eq!(
+            state.play(Player::Guest, 0, ClientMessage::AcceptRequest, &msg_tx),
+            Ok(())
+        );
+        assert!(!state.record.has_past());
+
+        // Sent for the game before the reset, including an opening stone
+        // that would be legal in the new game.
+        for (player, msg) in [
+            (Player::Host, ClientMessage::Resign),
+            (Player::Guest, ClientMessage::Resign),
+            (Player::Host, ClientMessage::Place(Point::new(1, 0), None)),
+            (Player::Guest, ClientMessage::Request(Request::Draw)),
+        ] {
+            assert_eq!(
+                state.play(player, 0, msg, &msg_tx),
+                Err(Rejection::StaleEpoch)
+            );
+        }
+        assert!(!state.record.has_past());
+        assert_eq!(state.requests[Player::Guest], None);
+
+        // Sent after acknowledging the reset.
+        assert_eq!(state.play(Player::Host, 1, place, &msg_tx), Ok(()));
+    }
+
+    #[test]
+    fn matched_seats() {
+        let (msg_tx, mut msg_rx) = broadcast::channel(CHANNEL_CAPACITY_GAME_MSG);
+        let mut state = Box::new(GameState::default());
+
+        // The guest may authenticate first, but not play alone.
+        let guest = Some(Player::Guest);
+        assert_eq!(state.authenticate(1, guest, &msg_tx), Some(Player::Guest));
+        assert_eq!(
+            state.play(Player::Guest, 0, ClientMessage::Resign, &msg_tx),
+            Err(Rejection::SeatsOpen)
+        );
+        assert!(msg_rx.try_recv().is_err());
+
+        // The host cannot take the guest's passcode, nor can the guest retake it.
+        let host = Some(Player::Host);
+        assert_eq!(state.authenticate(1, host, &msg_tx), None);
+        assert_eq!(state.authenticate(2, host, &msg_tx), Some(Player::Host));
+        assert!(matches!(msg_rx.try_recv(), Ok(ServerMessage::SeatsFilled)));
+        assert_eq!(state.authenticate(2, guest, &msg_tx), None);
+        assert_eq!(state.authenticate(1, guest, &msg_tx), Some(Player::Guest));
+
+        // Reconnecting through the game ID works as usual.
+        assert_eq!(state.authenticate(2, None, &msg_tx), Some(Player::Host));
+        assert_eq!(state.authenticate(3, None, &msg_tx), None);
+    }
+
+    /// Returns a random message that decodes, with small payload bytes
+    /// so that stones are placed near each other.
+    fn random_message() -> ClientMessage {
+        loop {
+            let len = rand::random::<u8>() % 6;
+            let buf: Vec<_> = iter::once(rand::random::<u8>() % 17)
+                .chain((0..len).map(|_| rand::random::<u8>() % 32))
+                .collect();
+            if let Some(msg) = ClientMessage::decode(&mut &buf[..]) {
+                return msg;
+            }
+        }
+    }
+
+    #[test]
+    fn play_random_messages() {
+        for _ in 0..200 {
+            let (mut state, msg_tx) = joined_state();
+            state.options.exact_six = rand::random();
+
+            for _ in 0..200 {
+                let player = if rand::random() {
+                    Player::Host
+                } else {
+                    Player::Guest
+                };
+                _ = state.play(player, state.epoch, random_message(), &msg_tx);
+                assert_eq!(state.record.check_invariants(), Ok(()));
+            }
+        }
+    }
+}
@@ -6,6 +6,7 @@ use std::{
     io,
     net::{IpAddr, Ipv4Addr, SocketAddr},
     path::PathBuf,
+    time::Duration,
 };
 use tokio::{
     net::{TcpListener, TcpSocket},
@@ -15,6 +16,10 @@ use tracing_subscriber::{layer::SubscriberExt, util::SubscriberInitExt};
 
 const DEFAULT_PORT: u16 = 8086;
 
+const DEFAULT_IDLE_TIMEOUT_SECS: u64 = 90;
+
+const DEFAULT_MAX_MESSAGE_SIZE: usize = 4096;
+
 const DEFAULT_LISTEN: &[SocketAddr] = &[SocketAddr::new(
     IpAddr::V4(Ipv4Addr::LOCALHOST),
     DEFAULT_PORT,
@@ -35,6 +40,14 @@ struct Args {
     /// Open the given database file
     #[arg(long, name = "FILE")]
     db_file: Option<PathBuf>,
+
+    /// Close connections idle for the given number of seconds
+    #[arg(long, name = "SECS", default_value_t = DEFAULT_IDLE_TIMEOUT_SECS)]
+    idle_timeout: u64,
+
+    /// Close connections sending messages larger than the given size
+    #[arg(long, name = "BYTES", default_value_t = DEFAULT_MAX_MESSAGE_SIZE)]
+    max_message_size: usize,
 }
 
 #[tokio::main(flavor = "current_thread")]
@@ -68,7 +81,15 @@ async fn main() -> anyhow::Result<()> {
 
     let shutdown_signal = shutdown_signal().context("failed to listen for shutdown signals")?;
 
-    c6ol_server::run(listeners, args.serve_dir, args.db_file, shutdown_signal).await;
+    c6ol_server::run(
+        listeners,
+        args.serve_dir,
+        args.db_file,
+        Duration::from_secs(args.idle_timeout),
+        args.max_message_size,
+        shutdown_signal,
+    )
+    .await;
     Ok(())
 }
 
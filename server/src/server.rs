@@ -1,19 +1,25 @@
 use crate::{db, game, shutdown, ws};
 use axum::{
     Router,
-    http::{HeaderValue, header},
+    extract::{Path, State},
+    http::{HeaderMap, HeaderValue, StatusCode, header},
     routing::get,
 };
-use std::{iter, path::PathBuf};
+use c6ol_core::protocol::{GameId, PasscodeHash};
+use chrono::DateTime;
+use std::{fmt::Write, iter, path::PathBuf, time::Duration};
 use tokio::{net::TcpListener, task::JoinSet};
 use tower::ServiceBuilder;
 use tower_http::{services::ServeDir, set_header::SetResponseHeaderLayer};
 
-/// Shared state for WebSocket handlers.
+/// Shared state for HTTP and WebSocket handlers.
 #[derive(Clone)]
 pub struct AppState {
     pub shutdown_rx: shutdown::Receiver,
+    pub db_manager: db::DbManager,
     pub game_manager: game::GameManager,
+    pub idle_timeout: Duration,
+    pub max_message_size: usize,
 }
 
 /// Runs the server.
@@ -21,6 +27,8 @@ pub async fn run(
     listeners: Vec<TcpListener>,
     serve_dir: Option<PathBuf>,
     db_file: Option<PathBuf>,
+    idle_timeout: Duration,
+    max_message_size: usize,
     shutdown_signal: impl Future<Output = ()> + Send + 'static,
 ) {
     // Set up graceful shutdown, on which the following events happen:
@@ -28,7 +36,7 @@ pub async fn run(
     // - All WebSocket handlers are cancelled, dropping all `GameManager`s
     //   (except the one shared by the axum servers) and `Game`s.
     // - The axum servers shut down after all connections are closed,
-    //   dropping the last `GameManager`.
+    //   dropping the last `GameManager` and the `DbManager` they share.
     // - All game tasks finish after no `Game`s are alive.
     // - The game manager task finishes after no `GameManager`s are alive
     //   and all game tasks finish.
@@ -40,16 +48,21 @@ pub async fn run(
     });
 
     let (db_manager, db_manager_task) = db::manager(db_file);
-    let (game_manager, game_manager_fut) = game::manager(db_manager);
+    let (game_manager, game_manager_fut) = game::manager(db_manager.clone());
     let game_manager_task = tokio::spawn(game_manager_fut);
 
     let app_state = AppState {
         shutdown_rx: shutdown_rx.clone(),
+        db_manager,
         game_manager,
+        idle_timeout,
+        max_message_size,
     };
 
     let mut app = Router::new()
         .route("/ws", get(ws::handle_websocket_upgrade))
+        .route("/retractions/{id}", get(get_retractions))
+        .route("/queue", get(get_queue_len))
         .with_state(app_state);
 
     if let Some(path) = serve_dir {
@@ -91,3 +104,48 @@ pub async fn run(
         tracing::error!("database manager task panicked: {err}");
     }
 }
+
+/// Returns the number of players waiting to be matched.
+async fn get_queue_len(State(state): State<AppState>) -> String {
+    state.game_manager.queue_len().await.to_string()
+}
+
+/// The header carrying the passcode hash of either seat of the game.
+const PASSCODE_HASH_HEADER: &str = "x-passcode-hash";
+
+/// Lists the saved retractions of a game in plain text, one per line.
+///
+/// Only players of the game may read them, by passing the passcode hash
+/// of their seat in the `X-Passcode-Hash` header.
+async fn get_retractions(
+    State(state): State<AppState>,
+    Path(id): Path<String>,
+    headers: HeaderMap,
+) -> Result<String, StatusCode> {
+    let id = GameId::from_base62(id.as_bytes()).ok_or(StatusCode::NOT_FOUND)?;
+    let hash: PasscodeHash = headers
+        .get(PASSCODE_HASH_HEADER)
+        .and_then(|value| value.to_str().ok()?.parse().ok())
+        .ok_or(StatusCode::UNAUTHORIZED)?;
+
+    // Don't tell a wrong passcode apart from a missing game.
+    let retractions = state
+        .db_manager
+        .retractions(id, hash)
+        .await
+        .ok_or(StatusCode::NOT_FOUND)?;
+
+    let mut s = String::new();
+    for r in retractions {
+        let time = DateTime::from_timestamp_millis(r.timestamp).unwrap_or_default();
+        _ = writeln!(
+            s,
+            "{}. {} retracted at {} on request of {:?}",
+            r.index + 1,
+            r.mov,
+            time.to_rfc3339(),
+            r.requester,
+        );
+    }
+    Ok(s)
+}
@@ -9,10 +9,13 @@ use axum::{
     },
     response::Response,
 };
-use c6ol_core::protocol::{ClientMessage, Message as _, ServerMessage};
-use futures_util::{SinkExt, StreamExt, future};
+use c6ol_core::protocol::{ClientMessage, Message as _, Player, ServerMessage};
+use futures_util::{SinkExt, StreamExt};
 use std::{convert::Infallible, time::Duration};
-use tokio::{sync::broadcast::error::RecvError, time};
+use tokio::{
+    sync::broadcast::error::RecvError,
+    time::{self, Instant},
+};
 
 /// Handles a WebSocket upgrade.
 #[remain::check]
@@ -20,9 +23,13 @@ pub async fn handle_websocket_upgrade(
     upgrade: WebSocketUpgrade,
     State(state): State<AppState>,
 ) -> Response {
+    // Oversized messages fail the read with an error, closing the connection.
+    let upgrade = upgrade
+        .max_message_size(state.max_message_size)
+        .max_frame_size(state.max_message_size);
     upgrade.on_upgrade(|mut socket| async move {
         let err = tokio::select! {
-            res = handle_websocket(&mut socket, state.game_manager) => {
+            res = handle_websocket(&mut socket, state.game_manager, state.idle_timeout) => {
                 let Err(err) = res;
                 err
             }
@@ -31,16 +38,23 @@ pub async fn handle_websocket_upgrade(
             }
         };
 
+        if let Error::Idle = err {
+            tracing::debug!("idle connection closed");
+        }
+
         #[sorted]
         let code = match &err {
             Error::Axum(_) => close_code::ERROR,
             Error::Closed => return,
             Error::GameNotFound => close_code::NORMAL,
+            Error::Idle => close_code::AWAY,
             Error::Lagged => close_code::AGAIN,
             Error::MalformedMessage => close_code::POLICY,
+            Error::PartnerMissing => close_code::NORMAL,
             Error::Shutdown => close_code::AWAY,
             Error::TextMessage => close_code::UNSUPPORTED,
             Error::UnexpectedMessage => close_code::POLICY,
+            Error::WatchRevoked => close_code::NORMAL,
             Error::WrongPasscode => close_code::NORMAL,
         };
         let msg = Message::Close(Some(CloseFrame {
@@ -60,16 +74,22 @@ enum Error {
     Closed,
     #[error("Game not found.")]
     GameNotFound,
+    #[error("Connection idle for too long.")]
+    Idle,
     #[error("Game desynced due to server lag.")]
     Lagged,
     #[error("Malformed message.")]
     MalformedMessage,
+    #[error("The matched player did not join.")]
+    PartnerMissing,
     #[error("The server is going down.")]
     Shutdown,
     #[error("Text message not supported.")]
     TextMessage,
     #[error("Unexpected message.")]
     UnexpectedMessage,
+    #[error("The watch link was revoked.")]
+    WatchRevoked,
     #[error("Wrong passcode.")]
     WrongPasscode,
 }
@@ -80,26 +100,44 @@ fn encode(msg: ServerMessage) -> Message {
 
 const HEARTBEAT_PERIOD: Duration = Duration::from_secs(30);
 
+/// How long a matched player waits for the partner to take their seat.
+const MATCH_JOIN_TIMEOUT: Duration = Duration::from_secs(120);
+
 // Handles a WebSocket connection.
 async fn handle_websocket(
     socket: &mut WebSocket,
     manager: GameManager,
+    idle_timeout: Duration,
 ) -> Result<Infallible, Error> {
-    let mut socket = socket.filter_map(|res| {
-        future::ready(match res {
-            Ok(Message::Binary(data)) => match ClientMessage::decode(&mut &data[..]) {
-                Some(msg) => Some(Ok(msg)),
-                None => Some(Err(Error::MalformedMessage)),
-            },
-            Ok(Message::Text(_)) => Some(Err(Error::TextMessage)),
-            Ok(_) => None,
-            Err(err) => Some(Err(err.into())),
-        })
+    // Frames other than messages are yielded as `None`, since any frame received,
+    // including a pong to our heartbeat, counts as activity.
+    let mut socket = socket.map(|res| match res {
+        Ok(Message::Binary(data)) => match ClientMessage::decode(&mut &data[..]) {
+            Some(msg) => Ok(Some(msg)),
+            None => Err(Error::MalformedMessage),
+        },
+        Ok(Message::Text(_)) => Err(Error::TextMessage),
+        Ok(_) => Ok(None),
+        Err(err) => Err(err.into()),
     });
 
+    let mut heartbeat_interval = time::interval(HEARTBEAT_PERIOD);
+
     let mut game;
+    // Whether the client only watches the game and may not authenticate.
+    let mut watching = false;
+    // When to give up on a matched game whose seats are not both taken,
+    // after which the client may queue again.
+    let mut join_deadline = None;
+
+    let msg = loop {
+        if let Some(msg) = socket.next().await.ok_or(Error::Closed)?? {
+            break msg;
+        }
+    };
+    let mut last_active = Instant::now();
 
-    match socket.next().await.ok_or(Error::Closed)?? {
+    match msg {
         ClientMessage::Start(options) => {
             game = manager.create(options).await;
 
@@ -109,29 +147,82 @@ async fn handle_websocket(
         ClientMessage::Join(id) => {
             game = manager.find(id).await.ok_or(Error::GameNotFound)?;
         }
+        ClientMessage::Watch(token) => {
+            game = manager.watch(token).await.ok_or(Error::GameNotFound)?;
+            watching = true;
+        }
+        ClientMessage::Match => {
+            let matched = manager.match_player();
+            tokio::pin!(matched);
+
+            // Leave the queue when the client closes, sends anything
+            // or goes idle while waiting.
+            game = loop {
+                let idle_deadline = last_active + idle_timeout;
+
+                tokio::select! {
+                    game = &mut matched => break game,
+                    opt = socket.next() => {
+                        last_active = Instant::now();
+                        if opt.ok_or(Error::Closed)??.is_some() {
+                            return Err(Error::UnexpectedMessage);
+                        }
+                    }
+                    _ = heartbeat_interval.tick() => {
+                        socket.send(Message::Ping(Bytes::new())).await?;
+                    }
+                    () = time::sleep_until(idle_deadline) => {
+                        return Err(Error::Idle);
+                    }
+                }
+            };
+
+            join_deadline = Some(Instant::now() + MATCH_JOIN_TIMEOUT);
+
+            let msg = ServerMessage::Started(game.id());
+            socket.send(encode(msg)).await?;
+        }
         _ => return Err(Error::UnexpectedMessage),
     }
 
-    let mut sub = game.subscribe().await;
+    // The watch token may be revoked before we subscribe.
+    let mut sub = game.subscribe().await.ok_or(Error::GameNotFound)?;
     for msg in sub.init_msgs {
+        if let ServerMessage::SeatsFilled = msg {
+            join_deadline = None;
+        }
         socket.send(encode(msg)).await?;
     }
 
-    let mut heartbeat_interval = time::interval(HEARTBEAT_PERIOD);
-
     loop {
+        let idle_deadline = last_active + idle_timeout;
+
         tokio::select! {
             res = sub.msg_rx.recv() => {
                 let msg = res.map_err(|err| match err {
                     RecvError::Closed => panic!("sender should be alive"),
                     RecvError::Lagged(_) => Error::Lagged,
                 })?;
+                if let ServerMessage::SeatsFilled = msg {
+                    join_deadline = None;
+                }
                 socket.send(encode(msg)).await?;
             }
+            res = sub.chat_rx.recv() => {
+                match res {
+                    Ok(msg) => socket.send(encode(msg)).await?,
+                    // Drop the chat messages we lagged behind instead of desyncing.
+                    Err(RecvError::Lagged(_)) => {}
+                    Err(RecvError::Closed) => panic!("sender should be alive"),
+                }
+            }
             opt = socket.next() => {
-                let msg = opt.ok_or(Error::Closed)??;
+                last_active = Instant::now();
+                let Some(msg) = opt.ok_or(Error::Closed)?? else {
+                    continue;
+                };
                 match msg {
-                    ClientMessage::Authenticate(hash) if game.player().is_none() => {
+                    ClientMessage::Authenticate(hash) if game.player().is_none() && !watching => {
                         let player =
                             game.authenticate(hash).await.ok_or(Error::WrongPasscode)?;
 
@@ -139,7 +230,30 @@ async fn handle_websocket(
                         socket.send(encode(msg)).await?;
                         continue;
                     }
-                    ClientMessage::Start(..) | ClientMessage::Join(_) | ClientMessage::Authenticate(_) => {
+                    ClientMessage::Chat(text) => {
+                        game.chat(text).await;
+                        continue;
+                    }
+                    ClientMessage::AckEpoch(epoch) => {
+                        game.ack_epoch(epoch);
+                        continue;
+                    }
+                    ClientMessage::GetWatchToken if game.player() == Some(Player::Host) => {
+                        let msg = ServerMessage::WatchToken(game.watch_token().await);
+                        socket.send(encode(msg)).await?;
+                        continue;
+                    }
+                    ClientMessage::RevokeWatchToken if game.player() == Some(Player::Host) => {
+                        game.revoke_watch_token().await;
+                        continue;
+                    }
+                    ClientMessage::Start(..)
+                    | ClientMessage::Join(_)
+                    | ClientMessage::Watch(_)
+                    | ClientMessage::Match
+                    | ClientMessage::Authenticate(_)
+                    | ClientMessage::GetWatchToken
+                    | ClientMessage::RevokeWatchToken => {
                         return Err(Error::UnexpectedMessage);
                     }
                     _ => {}
@@ -147,10 +261,24 @@ async fn handle_websocket(
                 if game.player().is_none() {
                     return Err(Error::UnexpectedMessage);
                 }
-                game.play(msg).await;
+                if let Err(reason) = game.play(msg).await {
+                    socket.send(encode(ServerMessage::Rejected(reason))).await?;
+                }
+            }
+            _ = sub.watch_revoked_rx.changed(), if watching => {
+                return Err(Error::WatchRevoked);
             }
             _ = heartbeat_interval.tick() => {
-                socket.send(Message::Pong(Bytes::new())).await?;
+                // Clients reply with a pong, which keeps the connection active.
+                socket.send(Message::Ping(Bytes::new())).await?;
+            }
+            () = time::sleep_until(idle_deadline) => {
+                return Err(Error::Idle);
+            }
+            () = time::sleep_until(join_deadline.unwrap_or(idle_deadline)),
+                if join_deadline.is_some() =>
+            {
+                return Err(Error::PartnerMissing);
             }
         }
     }